@@ -2,11 +2,9 @@ package main
 
 import (
 	"fmt"
-	"io"
 	"os/exec"
 	"runtime"
 	"strings"
-	"sync"
 )
 
 func viewAction(lw *LogWindow, commit Commit) {
@@ -39,7 +37,7 @@ func pushAction(lw *LogWindow, force bool, repository string) {
 	} else {
 		go func() {
 			err := execBackground(true, lw, "git", "push", repository)
-			if err != nil {
+			if err != nil && err != errJobCancelled {
 				lw.mu.Lock()
 				newForcePushPopup(lw.mw, repository, lw.edOutput.Buffer)
 				lw.mu.Unlock()
@@ -77,15 +75,15 @@ func diffAction(niceNameA, commitOrRefA, niceNameB, commitOrRefB string) {
 	NewDiffWindow(niceNameA, commitOrRefA, niceNameB, commitOrRefB)
 }
 
+// execBackground runs a command as a job showing its output in the graph
+// tab.
 func execBackground(wait bool, lw *LogWindow, cmdname string, args ...string) error {
 	var done chan struct{}
 	if wait {
 		done = make(chan struct{})
 	}
 
-	lw.edOutput.Buffer = lw.edOutput.Buffer[:0]
-	lw.edOutput.Buffer = append(lw.edOutput.Buffer, []rune(fmt.Sprintf("$ %s %s\n", cmdname, strings.Join(args, " ")))...)
-	lw.showOutput = true
+	header := fmt.Sprintf("$ %s %s\n", cmdname, strings.Join(args, " "))
 
 	var returnerror error
 	go func() {
@@ -93,44 +91,31 @@ func execBackground(wait bool, lw *LogWindow, cmdname string, args ...string) er
 			defer close(done)
 		}
 
-		cmd := exec.Command(cmdname, args...)
-		cmd.Dir = Repodir
+		defer checkSequencerStateAsync(lw.mw)
 
-		stdout, _ := cmd.StdoutPipe()
-		stderr, _ := cmd.StderrPipe()
+		cmd := editorCommand(cmdname, args...)
 
-		err := cmd.Start()
-
-		if err == nil {
+		onStart := func() {
+			// the output of the previous job is kept until this one
+			// starts
+			lw.mu.Lock()
+			lw.edOutput.Buffer = append(lw.edOutput.Buffer[:0], []rune(header)...)
+			lw.showOutput = true
+			lw.mu.Unlock()
 			lw.mw.Changed()
-			var wg sync.WaitGroup
-			filereader := func(fh io.ReadCloser) {
-				defer fh.Close()
-				defer wg.Done()
-				bsr := make([]byte, 4096)
-				for {
-					n, err := fh.Read(bsr)
-					if n > 0 {
-						lw.mu.Lock()
-						lw.edOutput.Buffer = append(lw.edOutput.Buffer, []rune(string(bsr[:n]))...)
-						lw.mw.Changed()
-						lw.mu.Unlock()
-					}
-					if err != nil {
-						break
-					}
-				}
-			}
-			wg.Add(2)
-			go filereader(stdout)
-			go filereader(stderr)
-			wg.Wait()
-			err = cmd.Wait()
 		}
+		onOutput := func(bs []byte) {
+			lw.mu.Lock()
+			lw.edOutput.Buffer = append(lw.edOutput.Buffer, []rune(string(bs))...)
+			lw.mw.Changed()
+			lw.mu.Unlock()
+		}
+
+		_, err := jobs.run(cmd, onStart, onOutput)
 
 		if err != nil {
 			returnerror = err
-			if !wait {
+			if !wait && err != errJobCancelled {
 				newMessagePopup(lw.mw, "Error", fmt.Sprintf("Error: %v\n", err))
 			}
 			return
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aarzilli/nucular"
+	"github.com/aarzilli/nucular/rect"
+)
+
+// The background fetch runs git fetch --all every conf.AutoFetch minutes,
+// remote-tracking branches that moved get a badge in the graph and a
+// notification is shown.
+
+const (
+	autoFetchCheck   = 30 * time.Second
+	autoFetchTimeout = 5 * time.Minute
+	notificationLife = 30 * time.Second
+)
+
+type refUpdate struct {
+	Ref      string // full name of the remote-tracking branch
+	Old, New string // Old is empty for new branches
+	Count    int    // number of new commits
+}
+
+// remoteRefIds returns the commit of each remote-tracking branch.
+func remoteRefIds() (map[string]string, error) {
+	out, err := execCommand("git", "for-each-ref", "--format=%(objectname) %(refname)", "refs/remotes")
+	if err != nil {
+		return nil, fmt.Errorf("git for-each-ref: %v\n%s", err, out)
+	}
+	r := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		if v := strings.SplitN(line, " ", 2); len(v) == 2 && !strings.HasSuffix(v[1], "/HEAD") {
+			r[v[1]] = v[0]
+		}
+	}
+	return r, nil
+}
+
+// movedRefs returns the references that are new or point to a different
+// commit in after, sorted by name.
+func movedRefs(before, after map[string]string) []refUpdate {
+	r := []refUpdate{}
+	for name, id := range after {
+		if before[name] != id {
+			r = append(r, refUpdate{Ref: name, Old: before[name], New: id})
+		}
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].Ref < r[j].Ref })
+	return r
+}
+
+// backgroundFetch fetches all remotes as a job, without asking for
+// credentials, and returns the remote-tracking branches that moved.
+func backgroundFetch(ctx context.Context) ([]refUpdate, string, error) {
+	before, err := remoteRefIds()
+	if err != nil {
+		return nil, "", err
+	}
+	cmd := exec.CommandContext(ctx, "git", "fetch", "--all", "--quiet")
+	cmd.Dir = Repodir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if sshcmd := batchSSHCommand(); sshcmd != "" {
+		cmd.Env = append(cmd.Env, "GIT_SSH_COMMAND="+sshcmd)
+	}
+	out, err := runJob(cmd)
+	if err != nil {
+		return nil, out, err
+	}
+	after, err := remoteRefIds()
+	if err != nil {
+		return nil, "", err
+	}
+	updates := movedRefs(before, after)
+	for i := range updates {
+		u := &updates[i]
+		if u.Old != "" {
+			u.Count, _ = countCommits(u.Old + ".." + u.New)
+			continue
+		}
+		// for new branches only the commits that were not on any other
+		// remote-tracking branch are new
+		flags := []string{}
+		for _, id := range before {
+			flags = append(flags, "^"+id)
+		}
+		u.Count, _ = countCommits(u.New, flags...)
+	}
+	return updates, out, nil
+}
+
+// batchSSHCommand returns the ssh command configured for git with
+// passphrase and password prompts disabled. It returns an empty string if
+// GIT_SSH is used, the program it names may not be OpenSSH.
+func batchSSHCommand() string {
+	sshcmd := os.Getenv("GIT_SSH_COMMAND")
+	if sshcmd == "" && os.Getenv("GIT_SSH") != "" {
+		return ""
+	}
+	if sshcmd == "" {
+		out, _ := execCommand("git", "config", "--get", "core.sshCommand")
+		sshcmd = strings.TrimSpace(out)
+	}
+	if sshcmd == "" {
+		sshcmd = "ssh"
+	}
+	return sshcmd + " -o BatchMode=yes"
+}
+
+func (u *refUpdate) String() string {
+	name := strings.TrimPrefix(u.Ref, "refs/remotes/")
+	switch {
+	case u.Old == "":
+		return fmt.Sprintf("%s: new branch with %d new commits", name, u.Count)
+	case u.Count == 1:
+		return fmt.Sprintf("%s: 1 new commit", name)
+	}
+	return fmt.Sprintf("%s: %d new commits", name, u.Count)
+}
+
+func autoFetchLoop(mw nucular.MasterWindow) {
+	var last time.Time
+	failed := false
+	for range time.Tick(autoFetchCheck) {
+		mw.Lock()
+		interval := time.Duration(conf.AutoFetch) * time.Minute
+		mw.Unlock()
+		if interval <= 0 || time.Since(last) < interval {
+			continue
+		}
+		last = time.Now()
+
+		ctx, cancel := context.WithTimeout(context.Background(), autoFetchTimeout)
+		updates, out, err := backgroundFetch(ctx)
+		cancel()
+		if err != nil {
+			// reported only once until a fetch succeeds again
+			if !failed {
+				notify(mw, fmt.Sprintf("Background fetch failed: %v %s", err, strings.SplitN(strings.TrimSpace(out), "\n", 2)[0]))
+			}
+			failed = true
+			continue
+		}
+		failed = false
+		if len(updates) == 0 {
+			continue
+		}
+
+		lw.mu.Lock()
+		for _, u := range updates {
+			lw.newCommits[u.Ref] += u.Count
+		}
+		lw.mu.Unlock()
+		for _, u := range updates {
+			notify(mw, u.String())
+		}
+		refreshAfterChange(mw, changeRefs)
+	}
+}
+
+type notification struct {
+	msg  string
+	when time.Time
+}
+
+var notifications []notification
+
+// notify shows msg at the top of the window for notificationLife.
+func notify(mw nucular.MasterWindow, msg string) {
+	mw.Lock()
+	notifications = append(notifications, notification{msg, time.Now()})
+	mw.Unlock()
+	mw.Changed()
+	time.AfterFunc(notificationLife, mw.Changed)
+}
+
+// showNotifications shows the notifications that have not expired, must
+// be called with the UI lock held.
+func showNotifications(w *nucular.Window) {
+	n := 0
+	for _, nt := range notifications {
+		if time.Since(nt.when) < notificationLife {
+			notifications[n] = nt
+			n++
+		}
+	}
+	notifications = notifications[:n]
+	for i := 0; i < len(notifications); i++ {
+		w.Row(20).Static(0, 20)
+		w.LabelColored(notifications[i].msg, "LC", refsColor)
+		if w.ButtonText("x") {
+			notifications = append(notifications[:i], notifications[i+1:]...)
+			i--
+		}
+	}
+}
+
+func newAutoFetchPopup(mw nucular.MasterWindow) {
+	enabled := conf.AutoFetch > 0
+	minutes := conf.AutoFetch
+	if minutes <= 0 {
+		minutes = 15
+	}
+	mw.PopupOpen("Background fetch...", popupFlags, rect.Rect{20, 100, 400, 180}, true, func(w *nucular.Window) {
+		w.Row(25).Dynamic(1)
+		w.CheckboxText("Fetch all remotes in the background", &enabled)
+		if enabled {
+			w.PropertyInt("Minutes between fetches:", 1, &minutes, 24*60, 1, 1)
+		}
+		ok, _ := okCancelButtons(w, true, "OK", true)
+		if ok {
+			conf.AutoFetch = 0
+			if enabled {
+				conf.AutoFetch = minutes
+			}
+			saveConfiguration()
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackgroundFetch(t *testing.T) {
+	dir := testRepo(t)
+	defer os.RemoveAll(dir)
+	tmp, err := ioutil.TempDir("", "fkgit-remote")
+	must(err)
+	defer os.RemoveAll(tmp)
+	remote := filepath.Join(tmp, "remote.git")
+	testGit(t, "init", "-q", "--bare", remote)
+	testGit(t, "push", "-q", remote, "HEAD:refs/heads/main")
+	testGit(t, "remote", "add", "origin", remote)
+	testGit(t, "fetch", "-q", "origin")
+
+	updates, out, err := backgroundFetch(context.Background())
+	if err != nil || len(updates) != 0 {
+		t.Fatalf("unexpected updates %v %v\n%s", updates, err, out)
+	}
+
+	// add commits to the remote from a second clone
+	testGit(t, "clone", "-q", "-b", "main", remote, filepath.Join(tmp, "clone"))
+	func() {
+		local := Repodir
+		defer func() { Repodir = local }()
+		Repodir = filepath.Join(tmp, "clone")
+		testWrite(t, "b.txt", "b\n")
+		testGit(t, "add", "b.txt")
+		testGit(t, "commit", "-q", "-m", "second")
+		testWrite(t, "b.txt", "bb\n")
+		testGit(t, "commit", "-q", "-a", "-m", "third")
+		testGit(t, "push", "-q", "origin", "HEAD:main", "HEAD:refs/heads/feature")
+	}()
+
+	updates, out, err = backgroundFetch(context.Background())
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if len(updates) != 2 {
+		t.Fatalf("wrong updates %#v", updates)
+	}
+	if u := updates[0]; u.Ref != "refs/remotes/origin/feature" || u.Old != "" || u.Count != 2 || u.String() != "origin/feature: new branch with 2 new commits" {
+		t.Errorf("wrong update %#v %q", u, u.String())
+	}
+	if u := updates[1]; u.Ref != "refs/remotes/origin/main" || u.Old == "" || u.Count != 2 || u.String() != "origin/main: 2 new commits" {
+		t.Errorf("wrong update %#v %q", u, u.String())
+	}
+
+	os.RemoveAll(remote)
+	if _, _, err := backgroundFetch(context.Background()); err == nil {
+		t.Errorf("expected error fetching from a missing remote")
+	}
+}
+
+func TestBatchSSHCommand(t *testing.T) {
+	dir := testRepo(t)
+	defer os.RemoveAll(dir)
+
+	t.Setenv("GIT_SSH_COMMAND", "")
+	t.Setenv("GIT_SSH", "")
+	if got := batchSSHCommand(); got != "ssh -o BatchMode=yes" {
+		t.Errorf("wrong command %q", got)
+	}
+	testGit(t, "config", "core.sshCommand", "ssh -i key")
+	if got := batchSSHCommand(); got != "ssh -i key -o BatchMode=yes" {
+		t.Errorf("wrong command %q", got)
+	}
+	t.Setenv("GIT_SSH", "/usr/bin/plink")
+	if got := batchSSHCommand(); got != "" {
+		t.Errorf("GIT_SSH overridden by %q", got)
+	}
+	t.Setenv("GIT_SSH_COMMAND", "myssh")
+	if got := batchSSHCommand(); got != "myssh -o BatchMode=yes" {
+		t.Errorf("wrong command %q", got)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/aarzilli/nucular"
+	"github.com/aarzilli/nucular/rect"
+)
+
+// branchInfo describes the upstream of a local branch.
+type branchInfo struct {
+	Upstream      string
+	Ahead, Behind int
+	Gone          bool // the upstream was deleted
+}
+
+func (bi *branchInfo) Track() string {
+	switch {
+	case bi.Upstream == "":
+		return ""
+	case bi.Gone:
+		return "gone"
+	case bi.Ahead == 0 && bi.Behind == 0:
+		return "="
+	}
+	return fmt.Sprintf("+%d -%d", bi.Ahead, bi.Behind)
+}
+
+// branchInfos returns the upstream of every local branch, by name of the
+// branch.
+func branchInfos() (map[string]branchInfo, error) {
+	out, err := execCommand("git", "for-each-ref", "--format=%(refname:strip=2)%00%(upstream:short)%00%(upstream:track,nobracket)", "refs/heads")
+	if err != nil {
+		return nil, fmt.Errorf("git for-each-ref: %v\n%s", err, out)
+	}
+	r := map[string]branchInfo{}
+	for _, line := range strings.Split(out, "\n") {
+		v := strings.SplitN(line, "\x00", 3)
+		if len(v) != 3 {
+			continue
+		}
+		bi := branchInfo{Upstream: v[1]}
+		bi.Ahead, bi.Behind, bi.Gone = parseTrack(v[2])
+		r[v[0]] = bi
+	}
+	return r, nil
+}
+
+// parseTrack parses the output of %(upstream:track,nobracket), for
+// example "ahead 1, behind 2" or "gone".
+func parseTrack(s string) (ahead, behind int, gone bool) {
+	for _, part := range strings.Split(s, ",") {
+		fields := strings.Fields(part)
+		switch {
+		case len(fields) == 1 && fields[0] == "gone":
+			gone = true
+		case len(fields) == 2 && fields[0] == "ahead":
+			ahead, _ = strconv.Atoi(fields[1])
+		case len(fields) == 2 && fields[0] == "behind":
+			behind, _ = strconv.Atoi(fields[1])
+		}
+	}
+	return
+}
+
+// mergedRefs returns the full names of the local and remote branches
+// merged into HEAD.
+func mergedRefs() (map[string]bool, error) {
+	out, err := execCommand("git", "for-each-ref", "--merged", "HEAD", "--format=%(refname)", "refs/heads", "refs/remotes")
+	if err != nil {
+		return nil, fmt.Errorf("git for-each-ref: %v\n%s", err, out)
+	}
+	r := map[string]bool{}
+	for _, name := range strings.Split(out, "\n") {
+		if name != "" {
+			r[name] = true
+		}
+	}
+	return r, nil
+}
+
+// deleteBranches deletes the local branches in names with git branch -d,
+// which refuses to delete branches that are not fully merged.
+func deleteBranches(names []string) (string, error) {
+	var buf strings.Builder
+	failed := 0
+	for _, name := range names {
+		out, err := runJob(editorCommand("git", "branch", "-d", name))
+		buf.WriteString(out)
+		if err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return buf.String(), fmt.Errorf("could not delete %d of %d branches", failed, len(names))
+	}
+	return buf.String(), nil
+}
+
+// deleteRemoteBranch deletes branch from remote and then its
+// remote-tracking branch, the remote-tracking branch is not touched if the
+// branch could not be deleted from the remote.
+func deleteRemoteBranch(remote, branch string) (string, error) {
+	out, err := runJob(editorCommand("git", "ls-remote", "--exit-code", remote, "refs/heads/"+branch))
+	exiterr, _ := err.(*exec.ExitError)
+	switch {
+	case exiterr != nil && exiterr.ExitCode() == 2:
+		// the branch does not exist on the remote, only the stale
+		// remote-tracking branch is left
+	case err != nil:
+		return out, fmt.Errorf("could not delete %s from %s, nothing was changed: %v", branch, remote, err)
+	default:
+		out, err = runJob(editorCommand("git", "push", remote, "--delete", branch))
+		if err != nil {
+			return out, fmt.Errorf("could not delete %s from %s, nothing was changed: %v", branch, remote, err)
+		}
+	}
+	tracking := remote + "/" + branch
+	if _, err := execCommand("git", "rev-parse", "-q", "--verify", "refs/remotes/"+tracking); err != nil {
+		return out, nil
+	}
+	out2, err := runJob(editorCommand("git", "branch", "-rD", tracking))
+	out += out2
+	if err != nil {
+		return out, fmt.Errorf("%s was deleted from %s but the remote-tracking branch %s could not be removed: %v", branch, remote, tracking, err)
+	}
+	return out, nil
+}
+
+type deleteMergedPopup struct {
+	mw       nucular.MasterWindow
+	names    []string
+	selected []bool
+}
+
+// newDeleteMergedPopup shows the local branches fully merged into HEAD and
+// deletes the selected ones.
+func newDeleteMergedPopup(mw nucular.MasterWindow, refs []Ref, merged map[string]bool) {
+	dp := &deleteMergedPopup{mw: mw}
+	for _, ref := range refs {
+		if ref.Kind == LocalRef && !ref.IsHEAD && merged[ref.Name] {
+			dp.names = append(dp.names, ref.nice)
+			dp.selected = append(dp.selected, true)
+		}
+	}
+	mw.PopupOpen("Delete merged branches...", popupFlags, rect.Rect{20, 100, 480, 400}, true, dp.Update)
+}
+
+func (dp *deleteMergedPopup) Update(w *nucular.Window) {
+	w.Row(25).Dynamic(1)
+	w.Label("Local branches merged into HEAD:", "LC")
+	w.Row(200).Dynamic(1)
+	if sw := w.GroupBegin("merged-branches", nucular.WindowBorder); sw != nil {
+		sw.Row(20).Dynamic(1)
+		if len(dp.names) == 0 {
+			sw.Label("No merged branches", "LC")
+		}
+		for i := range dp.names {
+			sw.CheckboxText(dp.names[i], &dp.selected[i])
+		}
+		sw.GroupEnd()
+	}
+
+	names := []string{}
+	for i := range dp.names {
+		if dp.selected[i] {
+			names = append(names, dp.names[i])
+		}
+	}
+	oktext := fmt.Sprintf("Delete %d", len(names))
+	if len(names) == 0 {
+		oktext = ""
+	}
+	ok, _ := okCancelButtons(w, false, oktext, true)
+	if ok {
+		go func() {
+			out, err := deleteBranches(names)
+			if err != nil {
+				newMessagePopup(dp.mw, "Error", fmt.Sprintf("Error: %v\n%s\n", err, out))
+			}
+			refreshAfterChange(dp.mw, changeRefs)
+		}()
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseTrack(t *testing.T) {
+	for _, tc := range []struct {
+		in            string
+		ahead, behind int
+		gone          bool
+	}{
+		{"", 0, 0, false},
+		{"ahead 1", 1, 0, false},
+		{"behind 12", 0, 12, false},
+		{"ahead 3, behind 2", 3, 2, false},
+		{"gone", 0, 0, true},
+	} {
+		ahead, behind, gone := parseTrack(tc.in)
+		if ahead != tc.ahead || behind != tc.behind || gone != tc.gone {
+			t.Errorf("%q: got %d %d %v", tc.in, ahead, behind, gone)
+		}
+	}
+}
+
+func TestBranches(t *testing.T) {
+	dir := testRepo(t)
+	defer os.RemoveAll(dir)
+	remote, err := ioutil.TempDir("", "fkgit-remote")
+	must(err)
+	defer os.RemoveAll(remote)
+	testGit(t, "init", "-q", "--bare", remote)
+	testGit(t, "remote", "add", "origin", remote)
+	testGit(t, "branch", "-M", "main")
+	testGit(t, "push", "-q", "-u", "origin", "main")
+
+	testGit(t, "branch", "merged")
+	testGit(t, "checkout", "-q", "-b", "other")
+	testWrite(t, "b.txt", "b\n")
+	testGit(t, "add", "b.txt")
+	testGit(t, "commit", "-q", "-m", "second")
+	testGit(t, "push", "-q", "origin", "other", "merged")
+	testGit(t, "checkout", "-q", "main")
+	testGit(t, "merge", "-q", "other")
+	testGit(t, "branch", "-f", "other", "HEAD~1")
+	testGit(t, "branch", "--set-upstream-to=origin/other", "other")
+	testGit(t, "checkout", "-q", "-b", "unmerged", "HEAD~1")
+	testWrite(t, "c.txt", "c\n")
+	testGit(t, "add", "c.txt")
+	testGit(t, "commit", "-q", "-m", "third")
+	testGit(t, "checkout", "-q", "main")
+
+	infos, err := branchInfos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bi := infos["main"]; bi.Upstream != "origin/main" || bi.Track() != "+1 -0" {
+		t.Errorf("wrong main %#v", bi)
+	}
+	if bi := infos["other"]; bi.Upstream != "origin/other" || bi.Track() != "+0 -1" {
+		t.Errorf("wrong other %#v", bi)
+	}
+	if bi := infos["unmerged"]; bi.Upstream != "" || bi.Track() != "" {
+		t.Errorf("wrong unmerged %#v", bi)
+	}
+
+	merged, err := mergedRefs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !merged["refs/heads/merged"] || !merged["refs/remotes/origin/other"] || merged["refs/heads/unmerged"] {
+		t.Errorf("wrong merged refs %v", merged)
+	}
+
+	if out, err := deleteBranches([]string{"merged", "unmerged"}); err == nil || !strings.Contains(err.Error(), "1 of 2") {
+		t.Errorf("expected partial failure: %v\n%s", err, out)
+	}
+	infos, _ = branchInfos()
+	if _, ok := infos["merged"]; ok {
+		t.Errorf("merged branch not deleted")
+	}
+	if _, ok := infos["unmerged"]; !ok {
+		t.Errorf("unmerged branch deleted")
+	}
+
+	if out, err := deleteRemoteBranch("origin", "merged"); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if out, err := execCommand("git", "rev-parse", "-q", "--verify", "refs/remotes/origin/merged"); err == nil {
+		t.Errorf("remote-tracking branch not deleted %s", out)
+	}
+
+	// stale remote-tracking branch
+	testGit(t, "--git-dir", remote, "branch", "-D", "other")
+	if out, err := deleteRemoteBranch("origin", "other"); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if _, err := execCommand("git", "rev-parse", "-q", "--verify", "refs/remotes/origin/other"); err == nil {
+		t.Errorf("stale remote-tracking branch not deleted")
+	}
+
+	if _, err := deleteRemoteBranch("nonexistent", "main"); err == nil || !strings.Contains(err.Error(), "nothing was changed") {
+		t.Errorf("expected failure: %v", err)
+	}
+	if _, err := execCommand("git", "rev-parse", "-q", "--verify", "refs/remotes/origin/main"); err != nil {
+		t.Errorf("remote-tracking branch deleted after failure")
+	}
+}
+
+func TestRefsTabLoad(t *testing.T) {
+	dir := testRepo(t)
+	defer os.RemoveAll(dir)
+	testGit(t, "branch", "other")
+	testGit(t, "tag", "-a", "-m", "tag message", "v1")
+
+	mw := &testMasterWindow{}
+	rt := &refsTab{mw: mw}
+	mw.Lock()
+	rt.loadRefs()
+	if !rt.loading || rt.refs != nil {
+		t.Errorf("references loaded with the UI lock held")
+	}
+	mw.Unlock()
+	testWaitFor(t, mw, "the references", func() bool { return !rt.loading })
+
+	names := []string{}
+	for _, ref := range rt.refs {
+		names = append(names, ref.Nice())
+		if rt.commits[ref.CommitId].Id != ref.CommitId {
+			t.Errorf("commit of %s not loaded", ref.Name)
+		}
+	}
+	if len(names) != 3 || rt.tags["v1"].Message != "tag message" {
+		t.Errorf("wrong references %v %#v", names, rt.tags)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/aarzilli/nucular"
+	"github.com/aarzilli/nucular/rect"
+)
+
+// Files larger than this are not copied before being deleted, unless the
+// user explicitly agrees to delete them without a copy.
+const maxCleanCopySize = 100 * 1024 * 1024
+
+type cleanEntry struct {
+	Path     string
+	Size     int64
+	Selected bool
+}
+
+func cleanArgs(ignored bool) []string {
+	args := []string{"--literal-pathspecs", "clean", "-d"}
+	if ignored {
+		args = append(args, "-x")
+	}
+	return args
+}
+
+// cleanCandidates returns the files that git clean would delete.
+func cleanCandidates(ignored bool) ([]cleanEntry, error) {
+	out, err := execCommand("git", append(cleanArgs(ignored), "-n")...)
+	if err != nil {
+		return nil, fmt.Errorf("git clean: %v\n%s", err, out)
+	}
+	r := []cleanEntry{}
+	for _, p := range parseCleanOutput(out) {
+		r = append(r, cleanEntry{Path: p, Size: diskUsage(filepath.Join(Repodir, p)), Selected: true})
+	}
+	return r, nil
+}
+
+// parseCleanOutput parses the output of git clean -n, paths with special
+// characters are quoted by git.
+func parseCleanOutput(out string) []string {
+	const prefix = "Would remove "
+	r := []string{}
+	for _, line := range strings.Split(out, "\n") {
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		p := line[len(prefix):]
+		if strings.HasPrefix(p, "\"") {
+			if s, err := strconv.Unquote(p); err == nil {
+				p = s
+			}
+		}
+		r = append(r, p)
+	}
+	return r
+}
+
+// diskUsage returns the size of path, including everything under it if
+// it is a directory.
+func diskUsage(path string) int64 {
+	var r int64
+	filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			r += info.Size()
+		}
+		return nil
+	})
+	return r
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n >= 1024*1024*1024:
+		return fmt.Sprintf("%.1f GiB", float64(n)/(1024*1024*1024))
+	case n >= 1024*1024:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1024*1024))
+	case n >= 1024:
+		return fmt.Sprintf("%.1f KiB", float64(n)/1024)
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// cleanFiles saves a copy of paths with snapshotWorktree, unless nocopy
+// is set, and deletes them.
+func cleanFiles(paths []string, ignored, nocopy bool) (string, error) {
+	if !nocopy {
+		if err := snapshotWorktree("Clean untracked files", paths...); err != nil {
+			return "", fmt.Errorf("could not save the files, nothing was deleted: %v", err)
+		}
+	}
+	args := append(cleanArgs(ignored), "-f", "-q", "--")
+	return runJob(editorCommand("git", append(args, paths...)...))
+}
+
+type cleanPopup struct {
+	mw      nucular.MasterWindow
+	ignored bool
+	nocopy  bool
+
+	loading bool
+	entries []cleanEntry
+	err     error
+}
+
+func newCleanPopup(mw nucular.MasterWindow) {
+	cp := &cleanPopup{mw: mw}
+	cp.reload()
+	mw.PopupOpen("Clean...", popupFlags, rect.Rect{20, 100, 640, 560}, true, cp.Update)
+}
+
+// reload loads the candidates in the background, must be called with the
+// UI lock held.
+func (cp *cleanPopup) reload() {
+	cp.loading = true
+	ignored := cp.ignored
+	go func() {
+		entries, err := cleanCandidates(ignored)
+		cp.mw.Lock()
+		defer cp.mw.Unlock()
+		if ignored == cp.ignored {
+			cp.entries, cp.err, cp.loading = entries, err, false
+		}
+		cp.mw.Changed()
+	}()
+}
+
+func (cp *cleanPopup) Update(w *nucular.Window) {
+	w.Row(25).Static(200, 100, 100)
+	if w.CheckboxText("Include ignored files", &cp.ignored) {
+		cp.reload()
+	}
+	if w.ButtonText("Select all") {
+		for i := range cp.entries {
+			cp.entries[i].Selected = true
+		}
+	}
+	if w.ButtonText("Select none") {
+		for i := range cp.entries {
+			cp.entries[i].Selected = false
+		}
+	}
+
+	w.Row(300).Dynamic(1)
+	if sw := w.GroupBegin("clean-list", nucular.WindowBorder); sw != nil {
+		switch {
+		case cp.loading:
+			sw.Row(20).Dynamic(1)
+			sw.Label("Loading...", "LC")
+		case cp.err != nil:
+			sw.Row(20).Dynamic(1)
+			sw.Label(strings.SplitN(cp.err.Error(), "\n", 2)[0], "LC")
+		case len(cp.entries) == 0:
+			sw.Row(20).Dynamic(1)
+			sw.Label("Nothing to clean", "LC")
+		}
+		if !cp.loading {
+			sw.Row(20).Static(0, 100)
+			for i := range cp.entries {
+				e := &cp.entries[i]
+				sw.CheckboxText(e.Path, &e.Selected)
+				sw.Label(formatSize(e.Size), "RC")
+			}
+		}
+		sw.GroupEnd()
+	}
+
+	paths := []string{}
+	var size int64
+	for _, e := range cp.entries {
+		if e.Selected && !cp.loading {
+			paths = append(paths, e.Path)
+			size += e.Size
+		}
+	}
+
+	w.Row(25).Dynamic(1)
+	w.Label(fmt.Sprintf("%d selected, %s", len(paths), formatSize(size)), "LC")
+	if size > maxCleanCopySize {
+		w.Label(fmt.Sprintf("The selected files are larger than %s and will not be copied.", formatSize(maxCleanCopySize)), "LC")
+		w.CheckboxText("Delete them without a copy", &cp.nocopy)
+	} else {
+		w.Label("A copy of the files will be kept in \"Recently discarded\".", "LC")
+	}
+
+	oktext := "Delete"
+	if len(paths) == 0 || (size > maxCleanCopySize && !cp.nocopy) {
+		oktext = ""
+	}
+	ok, _ := okCancelButtons(w, false, oktext, true)
+	if ok {
+		nocopy := size > maxCleanCopySize
+		ignored := cp.ignored
+		go func() {
+			out, err := cleanFiles(paths, ignored, nocopy)
+			if err != nil {
+				newMessagePopup(cp.mw, "Error", fmt.Sprintf("Error: %v\n%s\n", err, out))
+			}
+			idxmw.reload()
+		}()
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseCleanOutput(t *testing.T) {
+	out := "Would remove build/\nWould remove \"\\303\\250 space.txt\"\nWould skip repository nested/\nWould remove x.o\n"
+	if got := fmt.Sprintf("%q", parseCleanOutput(out)); got != `["build/" "è space.txt" "x.o"]` {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestClean(t *testing.T) {
+	dir := testRepo(t)
+	defer os.RemoveAll(dir)
+
+	testWrite(t, ".gitignore", "*.o\n")
+	testGit(t, "add", ".gitignore")
+	testGit(t, "commit", "-q", "-m", "ignore")
+	os.Mkdir(filepath.Join(dir, "build"), 0777)
+	testWrite(t, "build/out", "12345")
+	testWrite(t, "x.o", "object")
+	testWrite(t, "keep*.txt", "keep")
+
+	entries, err := cleanCandidates(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(entries); got != "[{build/ 5 true} {keep*.txt 4 true}]" {
+		t.Errorf("wrong candidates %s", got)
+	}
+	entries, err = cleanCandidates(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("wrong candidates with ignored files %v", entries)
+	}
+
+	if out, err := cleanFiles([]string{"build/", "x.o"}, true, false); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	for _, p := range []string{"build", "x.o"} {
+		if _, err := os.Stat(filepath.Join(dir, p)); err == nil {
+			t.Errorf("%s was not deleted", p)
+		}
+	}
+	if testRead(t, "keep*.txt") != "keep" {
+		t.Errorf("keep*.txt was changed")
+	}
+
+	snapshots := discardedSnapshots(1)
+	if len(snapshots) != 1 {
+		t.Fatalf("no snapshot")
+	}
+	if out, err := snapshots[0].restore(); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if testRead(t, "build/out") != "12345" || testRead(t, "x.o") != "object" {
+		t.Errorf("files not restored")
+	}
+}
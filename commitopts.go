@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/aarzilli/nucular"
+)
+
+// commitOptions are the options of git commit that can be set from the
+// Commit tab.
+type commitOptions struct {
+	show bool
+
+	signoff     bool
+	noVerify    bool
+	gpgSign     bool
+	resetAuthor bool // only used when amending
+	author      nucular.TextEditor
+	date        nucular.TextEditor
+}
+
+func (opts *commitOptions) init() {
+	opts.author.Flags = nucular.EditSelectable | nucular.EditFocusFollowsMouse | nucular.EditClipboard
+	opts.date.Flags = nucular.EditSelectable | nucular.EditFocusFollowsMouse | nucular.EditClipboard
+}
+
+// args returns the arguments for git to create a commit with the message
+// read from standard input.
+func (opts *commitOptions) args(amend bool) []string {
+	r := []string{"commit"}
+	if amend {
+		r = append(r, "--amend")
+		if opts.resetAuthor {
+			r = append(r, "--reset-author")
+		}
+	}
+	if opts.signoff {
+		r = append(r, "--signoff")
+	}
+	if opts.noVerify {
+		r = append(r, "--no-verify")
+	}
+	if opts.gpgSign {
+		r = append(r, "-S")
+	}
+	if author := strings.TrimSpace(string(opts.author.Buffer)); author != "" {
+		r = append(r, "--author="+author)
+	}
+	if date := strings.TrimSpace(string(opts.date.Buffer)); date != "" {
+		r = append(r, "--date="+date)
+	}
+	return append(r, "-F", "-")
+}
+
+// active returns true if any option is set.
+func (opts *commitOptions) active(amend bool) bool {
+	return len(opts.args(amend)) != len((&commitOptions{}).args(amend))
+}
+
+func (opts *commitOptions) Update(w *nucular.Window, amend bool) {
+	w.Row(25).Static(100, 100, 100, 120)
+	w.CheckboxText("Sign-off", &opts.signoff)
+	w.CheckboxText("No verify", &opts.noVerify)
+	w.CheckboxText("GPG sign", &opts.gpgSign)
+	if amend {
+		w.CheckboxText("Reset author", &opts.resetAuthor)
+	}
+	w.Row(25).Static(60, 0, 50, 200)
+	w.Label("Author:", "LC")
+	opts.author.Edit(w)
+	w.Label("Date:", "LC")
+	opts.date.Edit(w)
+}
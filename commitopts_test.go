@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCommitOptionsArgs(t *testing.T) {
+	var opts commitOptions
+	check := func(amend bool, tgt string) {
+		t.Helper()
+		if out := strings.Join(opts.args(amend), " "); out != tgt {
+			t.Errorf("got %q expected %q", out, tgt)
+		}
+	}
+
+	check(false, "commit -F -")
+	check(true, "commit --amend -F -")
+	if opts.active(false) {
+		t.Errorf("no option should be active")
+	}
+
+	opts.resetAuthor = true
+	check(false, "commit -F -")
+	check(true, "commit --amend --reset-author -F -")
+	if opts.active(false) || !opts.active(true) {
+		t.Errorf("reset author should only be active when amending")
+	}
+
+	opts.signoff = true
+	opts.noVerify = true
+	opts.gpgSign = true
+	opts.author.Buffer = []rune(" A U Thor <author@example.com> ")
+	opts.date.Buffer = []rune("2020-01-02T03:04:05")
+	check(false, "commit --signoff --no-verify -S --author=A U Thor <author@example.com> --date=2020-01-02T03:04:05 -F -")
+}
@@ -6,7 +6,8 @@ import (
 )
 
 type Configuration struct {
-	Scaling float64
+	Scaling   float64
+	AutoFetch int // minutes between background fetches, 0 to disable
 }
 
 var conf Configuration
@@ -0,0 +1,715 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/aarzilli/nucular"
+)
+
+type conflictChoice int
+
+const (
+	unresolvedChoice conflictChoice = iota
+	oursChoice
+	theirsChoice
+	bothChoice
+)
+
+// conflictSegment is either a piece of text that merged cleanly or a
+// conflict hunk, as delimited by conflict markers.
+type conflictSegment struct {
+	Conflict bool
+	Text     string // text of a clean segment or the raw text of a conflict hunk, including markers
+
+	Ours, Base, Theirs     string
+	OursLabel, TheirsLabel string
+	HasBase                bool
+	Choice                 conflictChoice
+}
+
+const conflictMarkerSize = 7
+
+func isConflictMarker(line string, ch byte) bool {
+	if len(line) < conflictMarkerSize {
+		return false
+	}
+	for i := 0; i < conflictMarkerSize; i++ {
+		if line[i] != ch {
+			return false
+		}
+	}
+	if len(line) == conflictMarkerSize {
+		return true
+	}
+	switch line[conflictMarkerSize] {
+	case ' ', '\n', '\r':
+		return true
+	}
+	return false
+}
+
+func markerLabel(line string) string {
+	return strings.TrimSpace(line[conflictMarkerSize:])
+}
+
+// parseConflicts splits text into clean segments and conflict hunks.
+func parseConflicts(text string) []conflictSegment {
+	const (
+		cleanState = iota
+		oursState
+		baseState
+		theirsState
+	)
+
+	r := []conflictSegment{}
+	var clean, raw bytes.Buffer
+	var cur conflictSegment
+	var ours, base, theirs bytes.Buffer
+	state := cleanState
+
+	flushClean := func() {
+		if clean.Len() > 0 {
+			r = append(r, conflictSegment{Text: clean.String()})
+			clean.Reset()
+		}
+	}
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if line == "" {
+			continue
+		}
+		switch state {
+		case cleanState:
+			if isConflictMarker(line, '<') {
+				flushClean()
+				cur = conflictSegment{Conflict: true, OursLabel: markerLabel(line)}
+				raw.Reset()
+				ours.Reset()
+				base.Reset()
+				theirs.Reset()
+				raw.WriteString(line)
+				state = oursState
+			} else {
+				clean.WriteString(line)
+			}
+		case oursState, baseState:
+			raw.WriteString(line)
+			switch {
+			case state == oursState && isConflictMarker(line, '|'):
+				cur.HasBase = true
+				state = baseState
+			case isConflictMarker(line, '='):
+				state = theirsState
+			case state == oursState:
+				ours.WriteString(line)
+			default:
+				base.WriteString(line)
+			}
+		case theirsState:
+			raw.WriteString(line)
+			if isConflictMarker(line, '>') {
+				cur.TheirsLabel = markerLabel(line)
+				cur.Text = raw.String()
+				cur.Ours, cur.Base, cur.Theirs = ours.String(), base.String(), theirs.String()
+				r = append(r, cur)
+				state = cleanState
+			} else {
+				theirs.WriteString(line)
+			}
+		}
+	}
+
+	if state != cleanState {
+		// unterminated conflict, leave it alone
+		clean.WriteString(raw.String())
+	}
+	flushClean()
+
+	return r
+}
+
+// resolveConflicts returns the text resulting from applying the choice of
+// each conflict hunk, unresolved hunks are left untouched.
+func resolveConflicts(segs []conflictSegment) string {
+	var buf bytes.Buffer
+	for _, seg := range segs {
+		buf.WriteString(seg.resolve(seg.Choice))
+	}
+	return buf.String()
+}
+
+// resolve returns the text of the segment resolved with choice.
+func (seg *conflictSegment) resolve(choice conflictChoice) string {
+	switch {
+	case !seg.Conflict || choice == unresolvedChoice:
+		return seg.Text
+	case choice == oursChoice:
+		return seg.Ours
+	case choice == theirsChoice:
+		return seg.Theirs
+	case choice == bothChoice:
+		return seg.Ours + seg.Theirs
+	}
+	return seg.Text
+}
+
+// replaceConflict resolves the first conflict hunk of text with the same
+// sides as seg, returns false if text has no such hunk.
+func replaceConflict(text string, seg conflictSegment, choice conflictChoice) (string, bool) {
+	var buf bytes.Buffer
+	found := false
+	for _, cur := range parseConflicts(text) {
+		if !found && cur.Conflict && cur.Ours == seg.Ours && cur.Theirs == seg.Theirs {
+			found = true
+			buf.WriteString(seg.resolve(choice))
+		} else {
+			buf.WriteString(cur.Text)
+		}
+	}
+	return buf.String(), found
+}
+
+// sameConflicts returns true if a and b have the same clean segments and
+// conflict hunks, ignoring labels and base.
+func sameConflicts(a, b []conflictSegment) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Conflict != b[i].Conflict {
+			return false
+		}
+		if a[i].Conflict && (a[i].Ours != b[i].Ours || a[i].Theirs != b[i].Theirs) {
+			return false
+		}
+		if !a[i].Conflict && a[i].Text != b[i].Text {
+			return false
+		}
+	}
+	return true
+}
+
+func hasConflictMarkers(text string) bool {
+	for _, seg := range parseConflicts(text) {
+		if seg.Conflict {
+			return true
+		}
+	}
+	return false
+}
+
+func hasConflicts() bool {
+	out, err := execCommand("git", "--no-optional-locks", "diff", "--name-only", "--diff-filter=U")
+	return err == nil && strings.TrimSpace(out) != ""
+}
+
+// stageContent returns the contents of path at the specified stage of the
+// index, or the empty string if the stage doesn't exist.
+func stageContent(stage int, path string) string {
+	cmd := exec.Command("git", "show", fmt.Sprintf(":%d:%s", stage, path))
+	cmd.Dir = Repodir
+	bs, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return string(bs)
+}
+
+// conflictStyleArgs returns the arguments of git merge-file that produce
+// conflicts in the style configured by merge.conflictStyle, the style used
+// for the conflicts written to the work tree.
+func conflictStyleArgs() []string {
+	out, _ := execCommand("git", "config", "--get", "merge.conflictStyle")
+	switch strings.TrimSpace(out) {
+	case "diff3":
+		return []string{"--diff3"}
+	case "zdiff3":
+		return []string{"--zdiff3"}
+	}
+	return nil
+}
+
+// mergeStages merges the three stages of path producing conflict markers in
+// the configured style.
+func mergeStages(path string) (string, error) {
+	dir, err := ioutil.TempDir("", "fkgit-conflict")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	names := []string{"ours", "base", "theirs"}
+	for i, stage := range []int{2, 1, 3} {
+		if err := ioutil.WriteFile(filepath.Join(dir, names[i]), []byte(stageContent(stage, path)), 0600); err != nil {
+			return "", err
+		}
+	}
+
+	args := append([]string{"merge-file", "-p"}, conflictStyleArgs()...)
+	args = append(args, "-L", "ours", "-L", "base", "-L", "theirs", filepath.Join(dir, "ours"), filepath.Join(dir, "base"), filepath.Join(dir, "theirs"))
+	cmd := exec.Command("git", args...)
+	cmd.Dir = Repodir
+	bs, err := cmd.Output()
+	if exiterr, ok := err.(*exec.ExitError); ok && exiterr.ExitCode() > 0 && exiterr.ExitCode() < 128 {
+		// the exit code is the number of conflicts
+		err = nil
+	}
+	return string(bs), err
+}
+
+type conflictFile struct {
+	line    StatusLine
+	segs    []conflictSegment
+	deleted bool // one of the sides deleted the file
+	ed      nucular.TextEditor
+	// generated is the last result computed from segs, if the editor
+	// contains something else it was edited by the user
+	generated string
+	split     nucular.ScalableSplit
+}
+
+func loadConflictFile(line StatusLine) (*conflictFile, error) {
+	cf := &conflictFile{line: line}
+	cf.ed.Flags = nucular.EditSelectable | nucular.EditMultiline | nucular.EditFocusFollowsMouse | nucular.EditClipboard
+	cf.split.MinSize = 80
+	cf.split.Size = 300
+	cf.split.Spacing = 5
+
+	switch line.Index + line.WorkDir {
+	case "DU", "UD", "DD":
+		cf.deleted = true
+		return cf, nil
+	}
+
+	merged, err := mergeStages(line.Path)
+	if err != nil {
+		return nil, err
+	}
+	cf.segs = parseConflicts(merged)
+
+	// the result starts from the working tree so that edits made outside of
+	// fkgit aren't lost
+	bs, err := ioutil.ReadFile(filepath.Join(Repodir, line.Path))
+	if err != nil {
+		return nil, err
+	}
+	cf.ed.Buffer = []rune(string(bs))
+	if sameConflicts(parseConflicts(string(bs)), cf.segs) {
+		cf.generated = string(bs)
+	}
+	return cf, nil
+}
+
+func (cf *conflictFile) resolved() (n, tot int) {
+	for _, seg := range cf.segs {
+		if seg.Conflict {
+			tot++
+			if seg.Choice != unresolvedChoice {
+				n++
+			}
+		}
+	}
+	return
+}
+
+// choose resolves the conflict hunks idxs with choice. If the result was
+// edited only the matching hunks of the result are replaced, choose returns
+// false without changing anything when they can not be found.
+func (cf *conflictFile) choose(idxs []int, choice conflictChoice) bool {
+	text := string(cf.ed.Buffer)
+	if text == cf.generated {
+		cf.overwrite(idxs, choice)
+		return true
+	}
+	for _, i := range idxs {
+		var ok bool
+		text, ok = replaceConflict(text, cf.segs[i], choice)
+		if !ok {
+			return false
+		}
+	}
+	for _, i := range idxs {
+		cf.segs[i].Choice = choice
+	}
+	cf.ed.Buffer = []rune(text)
+	return true
+}
+
+// overwrite resolves the conflict hunks idxs with choice and replaces the
+// result, discarding any edit.
+func (cf *conflictFile) overwrite(idxs []int, choice conflictChoice) {
+	for _, i := range idxs {
+		cf.segs[i].Choice = choice
+	}
+	cf.generated = resolveConflicts(cf.segs)
+	cf.ed.Buffer = []rune(cf.generated)
+	cf.ed.Cursor = 0
+}
+
+func (cf *conflictFile) conflicts() []int {
+	r := []int{}
+	for i := range cf.segs {
+		if cf.segs[i].Conflict {
+			r = append(r, i)
+		}
+	}
+	return r
+}
+
+// addedByOne returns true if the file was added by only one of the sides.
+func (cf *conflictFile) addedByOne() bool {
+	switch cf.line.Index + cf.line.WorkDir {
+	case "AU", "UA":
+		return true
+	}
+	return false
+}
+
+func (cf *conflictFile) describe() string {
+	switch cf.line.Index + cf.line.WorkDir {
+	case "DU":
+		return "deleted by us"
+	case "UD":
+		return "deleted by them"
+	case "DD":
+		return "deleted by both"
+	case "AU":
+		return "added by us"
+	case "UA":
+		return "added by them"
+	case "AA":
+		return "added by both"
+	}
+	return "modified by both"
+}
+
+type conflictTab struct {
+	mw       nucular.MasterWindow
+	files    []StatusLine
+	selected int
+	cf       *conflictFile
+	err      error
+	loading  bool
+	gen      int // incremented every time the list of files or the selected file is loaded
+	split    nucular.ScalableSplit
+}
+
+// newConflictTab opens the conflict resolution tab, or switches to it if it
+// is already open.
+func newConflictTab(mw nucular.MasterWindow) {
+	for _, tab := range tabs {
+		if ct, ok := tab.(*conflictTab); ok {
+			ct.reload()
+			currentTab = tabIndex(ct)
+			return
+		}
+	}
+	ct := &conflictTab{mw: mw, selected: -1}
+	ct.split.MinSize = 80
+	ct.split.Size = 200
+	ct.split.Spacing = 5
+	ct.reload()
+	openTab(ct)
+}
+
+// reload loads the list of conflicted files and the selected file in the
+// background, must be called with the UI lock held.
+func (ct *conflictTab) reload() {
+	oldselected := ""
+	if ct.selected >= 0 {
+		oldselected = ct.files[ct.selected].Path
+	}
+	ct.gen++
+	gen := ct.gen
+	ct.loading = true
+	go func() {
+		var files []StatusLine
+		status, err := gitStatus()
+		if err == nil {
+			files = status.Unmerged()
+		}
+		selected := -1
+		for i := range files {
+			if files[i].Path == oldselected {
+				selected = i
+			}
+		}
+		if selected < 0 && len(files) > 0 {
+			selected = 0
+		}
+		var cf *conflictFile
+		if selected >= 0 {
+			cf, err = loadConflictFile(files[selected])
+		}
+
+		ct.mw.Lock()
+		defer ct.mw.Unlock()
+		defer ct.mw.Changed()
+		if gen != ct.gen {
+			return
+		}
+		ct.loading = false
+		ct.files, ct.selected, ct.cf, ct.err = files, selected, cf, err
+		for _, tab := range tabs {
+			if st, ok := tab.(*sequencerTab); ok {
+				st.mu.Lock()
+				st.conflicts = !st.done && len(files) > 0
+				st.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// load loads the selected file in the background, must be called with the
+// UI lock held.
+func (ct *conflictTab) load() {
+	ct.cf, ct.err = nil, nil
+	if ct.selected < 0 {
+		return
+	}
+	ct.gen++
+	gen := ct.gen
+	line := ct.files[ct.selected]
+	go func() {
+		cf, err := loadConflictFile(line)
+		ct.mw.Lock()
+		defer ct.mw.Unlock()
+		if gen == ct.gen {
+			ct.cf, ct.err = cf, err
+		}
+		ct.mw.Changed()
+	}()
+}
+
+func (ct *conflictTab) Title() string {
+	return "Conflicts"
+}
+
+func (ct *conflictTab) Protected() bool {
+	return false
+}
+
+func (ct *conflictTab) Update(w *nucular.Window) {
+	area := w.Row(0).SpaceBegin(0)
+	leftbounds, rightbounds := ct.split.Vertical(w, area)
+
+	w.LayoutSpacePushScaled(leftbounds)
+	if sw := w.GroupBegin("conflict-files", nucular.WindowBorder); sw != nil {
+		sw.Row(25).Dynamic(1)
+		for i, line := range ct.files {
+			selected := i == ct.selected
+			sw.SelectableLabel(line.Path, "LC", &selected)
+			if selected && i != ct.selected {
+				ct.selected = i
+				ct.load()
+			}
+		}
+		if ct.loading && len(ct.files) == 0 {
+			sw.Label("Loading...", "LC")
+		} else if len(ct.files) == 0 {
+			sw.Label("All conflicts resolved", "LC")
+			for _, tab := range tabs {
+				st, ok := tab.(*sequencerTab)
+				if !ok {
+					continue
+				}
+				st.mu.Lock()
+				running := !st.done
+				st.mu.Unlock()
+				if running {
+					if sw.ButtonText("Back to " + st.Title()) {
+						closeTab(ct)
+						currentTab = tabIndex(st)
+					}
+					break
+				}
+			}
+		}
+		sw.GroupEnd()
+	}
+
+	w.LayoutSpacePushScaled(rightbounds)
+	if sw := w.GroupBegin("conflict-file", nucular.WindowBorder|nucular.WindowNoScrollbar); sw != nil {
+		switch {
+		case ct.err != nil:
+			sw.Row(25).Dynamic(1)
+			sw.Label(fmt.Sprintf("Error: %v", ct.err), "LC")
+		case ct.cf != nil:
+			ct.updateFile(sw)
+		case ct.selected >= 0:
+			sw.Row(25).Dynamic(1)
+			sw.Label("Loading...", "LC")
+		}
+		sw.GroupEnd()
+	}
+}
+
+func (ct *conflictTab) updateFile(w *nucular.Window) {
+	cf := ct.cf
+
+	if cf.deleted {
+		w.Row(25).Static(0, 100, 100)
+		w.Label(fmt.Sprintf("%s: %s", cf.line.Path, cf.describe()), "LC")
+		if w.ButtonText("Keep file") {
+			if cf.line.Index+cf.line.WorkDir == "DD" {
+				// deleted on both sides, there is nothing to add
+				ct.markResolved("rm")
+			} else {
+				ct.markResolved("add")
+			}
+		}
+		if w.ButtonText("Delete file") {
+			ct.markResolved("rm")
+		}
+		return
+	}
+
+	if cf.addedByOne() {
+		// there is nothing to merge, the file is either kept (possibly
+		// edited) or deleted
+		w.Row(25).Static(0, 100, 100)
+		w.Label(fmt.Sprintf("%s: %s", cf.line.Path, cf.describe()), "LC")
+		if w.ButtonText("Keep file") {
+			ct.writeResult(string(cf.ed.Buffer))
+		}
+		if w.ButtonText("Delete file") {
+			ct.markResolved("rm")
+		}
+		w.Row(0).Dynamic(1)
+		cf.ed.Edit(w)
+		return
+	}
+
+	n, tot := cf.resolved()
+	w.Row(25).Static(0, 100, 100, 120)
+	w.Label(fmt.Sprintf("%s: %s, %d/%d conflicts resolved", cf.line.Path, cf.describe(), n, tot), "LC")
+	if w.ButtonText("All ours") {
+		ct.choose(cf.conflicts(), oursChoice)
+	}
+	if w.ButtonText("All theirs") {
+		ct.choose(cf.conflicts(), theirsChoice)
+	}
+	if w.ButtonText("Mark resolved") {
+		result := string(cf.ed.Buffer)
+		if hasConflictMarkers(result) {
+			newMessagePopup(ct.mw, "Error", fmt.Sprintf("%s still contains conflict markers\n", cf.line.Path))
+		} else {
+			ct.writeResult(result)
+		}
+	}
+
+	area := w.Row(0).SpaceBegin(0)
+	hunksbounds, resultbounds := cf.split.Horizontal(w, area)
+
+	style := w.Master().Style()
+	lnh := nucular.FontHeight(style.Font)
+
+	w.LayoutSpacePushScaled(hunksbounds)
+	if sw := w.GroupBegin("conflict-hunks", nucular.WindowBorder); sw != nil {
+		cnt := 0
+		for i := range cf.segs {
+			seg := &cf.segs[i]
+			if !seg.Conflict {
+				continue
+			}
+			cnt++
+			sw.Row(25).Static(0, 80, 80, 80)
+			sw.Label(fmt.Sprintf("Conflict %d", cnt), "LC")
+			if sw.OptionText("Ours", seg.Choice == oursChoice) && seg.Choice != oursChoice {
+				ct.choose([]int{i}, oursChoice)
+			}
+			if sw.OptionText("Theirs", seg.Choice == theirsChoice) && seg.Choice != theirsChoice {
+				ct.choose([]int{i}, theirsChoice)
+			}
+			if sw.OptionText("Both", seg.Choice == bothChoice) && seg.Choice != bothChoice {
+				ct.choose([]int{i}, bothChoice)
+			}
+
+			// the base is only known with the diff3 and zdiff3 styles
+			names, texts := []string{"Ours", "Theirs"}, []string{seg.Ours, seg.Theirs}
+			if seg.HasBase {
+				names, texts = []string{"Base", "Ours", "Theirs"}, []string{seg.Base, seg.Ours, seg.Theirs}
+			}
+			sw.Row(20).Dynamic(len(names))
+			for _, name := range names {
+				sw.Label(name, "LC")
+			}
+
+			nlines := max(strings.Count(seg.Base, "\n"), max(strings.Count(seg.Ours, "\n"), strings.Count(seg.Theirs, "\n")))
+			if nlines > 15 {
+				nlines = 15
+			}
+			sw.RowScaled((nlines+1)*lnh + 2*style.GroupWindow.Padding.Y).Dynamic(len(texts))
+			for j, text := range texts {
+				if hw := sw.GroupBegin(fmt.Sprintf("conflict-hunk-%d-%d", i, j), nucular.WindowBorder); hw != nil {
+					hw.RowScaled(lnh).Dynamic(1)
+					showLines(hw, expandtabs(text))
+					hw.GroupEnd()
+				}
+			}
+		}
+		sw.GroupEnd()
+	}
+
+	w.LayoutSpacePushScaled(resultbounds)
+	if sw := w.GroupBegin("conflict-result", nucular.WindowNoScrollbar); sw != nil {
+		sw.Row(20).Dynamic(1)
+		sw.Label("Result:", "LC")
+		sw.Row(0).Dynamic(1)
+		cf.ed.Edit(sw)
+		sw.GroupEnd()
+	}
+}
+
+// choose resolves the conflict hunks idxs of the current file with choice,
+// asking before discarding the edits made to the result.
+func (ct *conflictTab) choose(idxs []int, choice conflictChoice) {
+	cf := ct.cf
+	if cf.choose(idxs, choice) {
+		return
+	}
+	newConfirmPopup(ct.mw, "Discard edits", "The result was edited, discard the edits?", "Discard", func() {
+		cf.overwrite(idxs, choice)
+	})
+}
+
+func (ct *conflictTab) writeResult(result string) {
+	path := filepath.Join(Repodir, ct.cf.line.Path)
+	mode := os.FileMode(0666)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode()
+	}
+	if err := ioutil.WriteFile(path, []byte(result), mode); err != nil {
+		newMessagePopup(ct.mw, "Error", fmt.Sprintf("Error writing %s: %v\n", ct.cf.line.Path, err))
+		return
+	}
+	ct.markResolved("add")
+}
+
+func (ct *conflictTab) markResolved(cmd string) {
+	path := ct.cf.line.Path
+	go func() {
+		out, err := runJob(editorCommand("git", cmd, "--", path))
+		if err != nil && err != errJobCancelled {
+			newMessagePopup(ct.mw, "Error", fmt.Sprintf("Error: %v\n%s\n", err, out))
+		}
+		ct.mw.Lock()
+		ct.reload()
+		ct.mw.Unlock()
+		ct.mw.Changed()
+		idxmw.reload()
+	}()
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aarzilli/nucular"
+	"github.com/aarzilli/nucular/rect"
+)
+
+// testMasterWindow is a master window that is never shown, only locking
+// is implemented.
+type testMasterWindow struct {
+	nucular.MasterWindow
+	mu sync.Mutex
+}
+
+func (mw *testMasterWindow) Lock()    { mw.mu.Lock() }
+func (mw *testMasterWindow) Unlock()  { mw.mu.Unlock() }
+func (mw *testMasterWindow) Changed() {}
+func (mw *testMasterWindow) PopupOpen(title string, flags nucular.WindowFlags, r rect.Rect, scale bool, updateFn nucular.UpdateFn) {
+}
+
+// testWaitFor waits until cond, called with the UI lock held, returns true.
+func testWaitFor(t *testing.T, mw nucular.MasterWindow, what string, cond func() bool) {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		mw.Lock()
+		ok := cond()
+		mw.Unlock()
+		if ok {
+			return
+		}
+	}
+	t.Fatalf("timeout waiting for %s", what)
+}
+
+func TestParseConflicts(t *testing.T) {
+	text := `a
+<<<<<<< ours
+b
+||||||| base
+c
+=======
+d
+>>>>>>> theirs
+e
+<<<<<<< HEAD
+f
+=======
+g
+>>>>>>> other
+<<<<<<< unterminated
+h
+`
+	segs := parseConflicts(text)
+	if len(segs) != 5 {
+		t.Fatalf("wrong number of segments %d: %#v", len(segs), segs)
+	}
+	if segs[0].Conflict || segs[0].Text != "a\n" {
+		t.Errorf("wrong first segment %#v", segs[0])
+	}
+	c := segs[1]
+	if !c.Conflict || !c.HasBase || c.Ours != "b\n" || c.Base != "c\n" || c.Theirs != "d\n" || c.OursLabel != "ours" || c.TheirsLabel != "theirs" {
+		t.Errorf("wrong first conflict %#v", c)
+	}
+	c = segs[3]
+	if !c.Conflict || c.HasBase || c.Ours != "f\n" || c.Theirs != "g\n" {
+		t.Errorf("wrong second conflict %#v", c)
+	}
+	if segs[4].Conflict || segs[4].Text != "<<<<<<< unterminated\nh\n" {
+		t.Errorf("wrong last segment %#v", segs[4])
+	}
+
+	if out := resolveConflicts(segs); out != text {
+		t.Errorf("unresolved text changed:\n%s", out)
+	}
+
+	segs[1].Choice = theirsChoice
+	segs[3].Choice = bothChoice
+	if out, tgt := resolveConflicts(segs), "a\nd\ne\nf\ng\n<<<<<<< unterminated\nh\n"; out != tgt {
+		t.Errorf("wrong resolution:\n%s", out)
+	}
+	if !hasConflictMarkers(text) || hasConflictMarkers("a\n<<<<<<<< not a marker\n") {
+		t.Errorf("hasConflictMarkers")
+	}
+}
+
+func TestConflictChoose(t *testing.T) {
+	merged := "a\n<<<<<<< ours\nb\n||||||| base\nc\n=======\nd\n>>>>>>> theirs\ne\n<<<<<<< ours\nf\n=======\ng\n>>>>>>> theirs\n"
+	cf := &conflictFile{segs: parseConflicts(merged)}
+	cf.ed.Buffer = []rune(merged)
+	cf.generated = merged
+
+	check := func(tgt string) {
+		t.Helper()
+		if out := string(cf.ed.Buffer); out != tgt {
+			t.Errorf("wrong result:\n%s\nexpected:\n%s", out, tgt)
+		}
+	}
+
+	// unedited results are recomputed
+	if !cf.choose([]int{1}, oursChoice) || !cf.choose([]int{1}, theirsChoice) {
+		t.Fatalf("choice on unedited result failed")
+	}
+	check("a\nd\ne\n<<<<<<< ours\nf\n=======\ng\n>>>>>>> theirs\n")
+
+	// edits are kept
+	cf.ed.Buffer = []rune("edited\n" + string(cf.ed.Buffer))
+	if !cf.choose([]int{3}, bothChoice) {
+		t.Fatalf("choice on edited result failed")
+	}
+	check("edited\na\nd\ne\nf\ng\n")
+
+	// the hunk was already resolved in the edited result
+	if cf.choose([]int{1}, oursChoice) {
+		t.Errorf("choice on resolved hunk succeeded")
+	}
+	check("edited\na\nd\ne\nf\ng\n")
+	if cf.segs[1].Choice != theirsChoice {
+		t.Errorf("failed choice changed the hunk")
+	}
+
+	cf.overwrite([]int{1}, oursChoice)
+	check("a\nb\ne\nf\ng\n")
+}
+
+func TestConflictTabFromSequencer(t *testing.T) {
+	dir := testRepo(t)
+	defer os.RemoveAll(dir)
+	testGit(t, "checkout", "-q", "-b", "other")
+	testWrite(t, "a.txt", "other\n")
+	testGit(t, "commit", "-q", "-a", "-m", "other")
+	testGit(t, "checkout", "-q", "-")
+	testWrite(t, "a.txt", "main\n")
+	testGit(t, "commit", "-q", "-a", "-m", "main")
+	testGitConflict(t, "merge", "other")
+
+	oldtabs, oldcur := tabs, currentTab
+	defer func() { tabs, currentTab = oldtabs, oldcur }()
+	mw := &testMasterWindow{}
+	st := newSequencerTab(mw, mergeOp)
+	st.conflicts = true
+	tabs = []Tab{st}
+
+	// "Resolve conflicts" is clicked while the UI lock is held and the
+	// sequencer tab is being drawn
+	mw.Lock()
+	st.mu.Lock()
+	done := make(chan struct{})
+	go func() {
+		newConflictTab(mw)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("opening the conflict tab blocked")
+	}
+	st.mu.Unlock()
+	mw.Unlock()
+
+	var ct *conflictTab
+	testWaitFor(t, mw, "the conflict tab to load", func() bool {
+		ct, _ = tabs[currentTab].(*conflictTab)
+		return ct != nil && !ct.loading && (ct.cf != nil || ct.err != nil)
+	})
+	if ct.err != nil || len(ct.files) != 1 || ct.cf.line.Path != "a.txt" {
+		t.Fatalf("wrong conflict tab %v %#v", ct.err, ct.files)
+	}
+	st.mu.Lock()
+	if !st.conflicts {
+		t.Errorf("sequencer tab not updated")
+	}
+	st.mu.Unlock()
+
+	// resolving the last conflict updates the sequencer tab
+	testGit(t, "add", "a.txt")
+	mw.Lock()
+	ct.reload()
+	mw.Unlock()
+	testWaitFor(t, mw, "the conflict to be resolved", func() bool {
+		return !ct.loading && len(ct.files) == 0
+	})
+	st.mu.Lock()
+	if st.conflicts {
+		t.Errorf("sequencer tab still has conflicts")
+	}
+	st.mu.Unlock()
+}
+
+func TestLoadConflictFileStyle(t *testing.T) {
+	dir := testRepo(t)
+	defer os.RemoveAll(dir)
+	testWrite(t, "a.txt", "a\nb\nc\n")
+	testGit(t, "commit", "-q", "-a", "-m", "base")
+	testGit(t, "checkout", "-q", "-b", "other")
+	testWrite(t, "a.txt", "a\nY\nsame\nc\n")
+	testGit(t, "commit", "-q", "-a", "-m", "other")
+	testGit(t, "checkout", "-q", "-")
+	testWrite(t, "a.txt", "a\nX\nsame\nc\n")
+	testGit(t, "commit", "-q", "-a", "-m", "ours")
+	testGitConflict(t, "merge", "other")
+
+	// the merge style moves the common line out of the conflict, diff3
+	// doesn't
+	for _, style := range []string{"merge", "diff3", "zdiff3"} {
+		testGit(t, "config", "merge.conflictStyle", style)
+		testGit(t, "checkout", "--conflict="+style, "--", "a.txt")
+		status, err := gitStatus()
+		must(err)
+		cf, err := loadConflictFile(status.Unmerged()[0])
+		if err != nil {
+			t.Fatalf("%s: %v", style, err)
+		}
+		if string(cf.ed.Buffer) != cf.generated {
+			t.Errorf("%s: work tree not recognized as unedited:\n%s", style, string(cf.ed.Buffer))
+		}
+		if !cf.choose(cf.conflicts(), theirsChoice) || string(cf.ed.Buffer) != "a\nY\nsame\nc\n" {
+			t.Errorf("%s: wrong result:\n%s", style, string(cf.ed.Buffer))
+		}
+	}
+}
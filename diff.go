@@ -5,6 +5,9 @@ import (
 	"bytes"
 	"fmt"
 	"image/color"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"unicode"
 
@@ -22,7 +25,19 @@ type FileDiff struct {
 	Filedescr string
 	Headers   []Chunk
 	Lines     []LineDiff
+	Hunks     []Hunk
+	Binary    bool
+	OldHash   string // hashes from the index header
+	NewHash   string
+	Info      []string // additional description of the change, shown after the headers
 	rtxt      *richtext.RichText
+	lineOff   []int32 // offset in rtxt of each line in Lines
+}
+
+type Hunk struct {
+	Header string   // raw hunk header
+	Lines  []string // raw lines of the hunk
+	Start  int      // index of the hunk header in FileDiff.Lines
 }
 
 type LineDiffOpts int
@@ -64,6 +79,7 @@ func (ld *LineDiff) IsHunkHeader() bool {
 
 func parseDiff(bs []byte) Diff {
 	rdr := bufio.NewScanner(bytes.NewReader(bs))
+	rdr.Buffer(nil, len(bs)+1)
 
 	var diff Diff
 	var filediff *FileDiff
@@ -91,7 +107,14 @@ func parseDiff(bs []byte) Diff {
 				dellines++
 			}
 		}
-		filediff.Filedescr = fmt.Sprintf("%s +%d-%d", filediff.Filename, addlines, dellines)
+		if filediff.Filename == "" && len(filediff.Headers) > 0 {
+			filediff.Filename = filenameFromHeader(filediff.Headers[0].Text)
+		}
+		if filediff.Binary {
+			filediff.Filedescr = fmt.Sprintf("%s (binary)", filediff.Filename)
+		} else {
+			filediff.Filedescr = fmt.Sprintf("%s +%d-%d", filediff.Filename, addlines, dellines)
+		}
 		diff = append(diff, *filediff)
 		filediffBody = false
 		filediff = &FileDiff{}
@@ -116,16 +139,30 @@ func parseDiff(bs []byte) Diff {
 			filediff = &FileDiff{}
 			filediff.Headers = append(filediff.Headers, Chunk{Opts: Filehdr, Text: text})
 		} else if filediff != nil && !filediffBody {
+			if strings.HasPrefix(text, "diff ") {
+				// the previous file had no body (binary, empty or mode change)
+				flushFilediff()
+				filediff.Headers = append(filediff.Headers, Chunk{Opts: Filehdr, Text: text})
+				continue
+			}
 			filediff.Headers = append(filediff.Headers, Chunk{Opts: Filehdr, Text: text})
+			switch {
+			case strings.HasPrefix(text, "Binary files ") || text == "GIT binary patch":
+				filediff.Binary = true
+			case strings.HasPrefix(text, "index "):
+				filediff.OldHash, filediff.NewHash = parseIndexHeader(text)
+			}
 			if strings.HasPrefix(text, delprefix) {
 				delfile := text[len(delprefix):]
 				if strings.HasPrefix(delfile, delprefixExtra) {
 					delfile = delfile[len(delprefixExtra):]
 				}
-				rdr.Scan()
+				if !rdr.Scan() {
+					break
+				}
 				text = rdr.Text()
 				filediff.Headers = append(filediff.Headers, Chunk{Opts: Filehdr, Text: text})
-				addfile := text[len(addprefix):]
+				addfile := strings.TrimPrefix(text, addprefix)
 				if strings.HasPrefix(addfile, addprefixExtra) {
 					addfile = addfile[len(addprefixExtra):]
 				}
@@ -141,14 +178,18 @@ func parseDiff(bs []byte) Diff {
 				flushFilediff()
 				lines = []string{}
 				filediff.Headers = append(filediff.Headers, Chunk{Opts: Filehdr, Text: text})
-			} else if text[0] == '@' {
+			} else if len(text) > 0 && text[0] == '@' {
 				if len(lines) > 0 {
 					filediff.Lines = append(filediff.Lines, diffLines(lines, merge)...)
 				}
 				lines = []string{}
+				filediff.Hunks = append(filediff.Hunks, Hunk{Header: text, Start: len(filediff.Lines)})
 				filediff.Lines = append(filediff.Lines, parseHunkHeader(text))
 			} else {
 				lines = append(lines, text)
+				if n := len(filediff.Hunks); n > 0 {
+					filediff.Hunks[n-1].Lines = append(filediff.Hunks[n-1].Lines, text)
+				}
 			}
 		}
 	}
@@ -165,6 +206,73 @@ func parseDiff(bs []byte) Diff {
 	return diff
 }
 
+// filenameFromHeader returns the name of the file from the first line of
+// a file diff ("diff --git a/name b/name" or "diff --cc name").
+func filenameFromHeader(text string) string {
+	if strings.HasPrefix(text, "diff --git ") {
+		text = text[len("diff --git "):]
+		if i := strings.LastIndex(text, " b/"); i >= 0 {
+			return text[i+len(" b/"):]
+		}
+		return text
+	}
+	if i := strings.LastIndex(text, " "); i >= 0 {
+		return text[i+1:]
+	}
+	return text
+}
+
+// parseIndexHeader returns the hashes in a "index old..new mode" line
+func parseIndexHeader(text string) (oldhash, newhash string) {
+	fields := strings.Fields(text)
+	if len(fields) < 2 {
+		return "", ""
+	}
+	v := strings.SplitN(fields[1], "..", 2)
+	if len(v) != 2 {
+		return "", ""
+	}
+	return v[0], v[1]
+}
+
+// errorDiff returns a diff that describes an error loading the diff of path
+func errorDiff(path, out string, err error) Diff {
+	info := []string{fmt.Sprintf("Error: %v", err)}
+	if out = strings.TrimSpace(out); out != "" {
+		info = append(info, strings.Split(out, "\n")...)
+	}
+	return Diff{{Filename: path, Filedescr: path, Info: info, rtxt: richtext.New(richtext.Selectable | richtext.Clipboard)}}
+}
+
+// describeBinary adds the size and hash of the old and new version of each
+// binary file in diff to its description.
+func describeBinary(diff Diff) {
+	for i := range diff {
+		fd := &diff[i]
+		if fd.Binary {
+			fd.Info = append(fd.Info, "old: "+describeBlob(fd.OldHash, ""), "new: "+describeBlob(fd.NewHash, fd.Filename))
+		}
+	}
+}
+
+// describeBlob returns the size and hash of a blob, if the blob isn't in the
+// object database the file in the work tree is used instead.
+func describeBlob(hash, path string) string {
+	if strings.Trim(hash, "0") == "" {
+		return "none"
+	}
+	if out, err := execCommand("git", "cat-file", "-s", hash); err == nil {
+		return fmt.Sprintf("%s bytes, blob %s", strings.TrimSpace(out), hash)
+	}
+	if path != "" {
+		if fi, err := os.Stat(filepath.Join(Repodir, path)); err == nil {
+			out, _ := execCommand("git", "hash-object", "--", path)
+			return fmt.Sprintf("%d bytes, blob %s (work tree)", fi.Size(), strings.TrimSpace(out))
+		}
+	}
+	return "blob " + hash
+}
+
 func expandtabs(text string) string {
 	if strings.Index(text, "\t") < 0 {
 		return text
@@ -222,9 +330,15 @@ func diffLinesCombined(lines []string) []LineDiff {
 	r := make([]LineDiff, len(lines))
 	for i, line := range lines {
 		opts := LineDiffOptsNone
-		if line[0] == '+' || line[1] == '+' {
+		at := func(i int) byte {
+			if i < len(line) {
+				return line[i]
+			}
+			return 0
+		}
+		if at(0) == '+' || at(1) == '+' {
 			opts = Addline
-		} else if line[0] == '-' || line[1] == '-' {
+		} else if at(0) == '-' || at(1) == '-' {
 			opts = Delline
 		}
 
@@ -283,6 +397,9 @@ func diffLinesWord(lines []string) []LineDiff {
 	}
 
 	for _, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
 		switch line[0] {
 		case ' ':
 			flushBlock()
@@ -541,11 +658,21 @@ func wordSplit(in string) []string {
 }
 
 func showDiff(w *nucular.Window, diff Diff) {
-	style := w.Master().Style()
+	showDiffHunks(w, diff)
+	scrollingKeys(w, nucular.FontHeight(w.Master().Style().Font))
+}
 
-	lnh := nucular.FontHeight(style.Font)
+type hunkAction struct {
+	name string
+	fn   func(fd *FileDiff, hunk int, sel []bool)
+}
 
-	for _, filediff := range diff {
+// showDiffHunks is like showDiff but adds two links to each hunk header for
+// every action, one to apply the action to the whole hunk and one to apply
+// it to the lines of the hunk that are currently selected.
+func showDiffHunks(w *nucular.Window, diff Diff, actions ...hunkAction) {
+	for i := range diff {
+		filediff := &diff[i]
 		if w.TreePushNamed(nucular.TreeTab, filediff.Filename, filediff.Filedescr, true) {
 			c := filediff.rtxt.Rows(w, false)
 			if c == nil {
@@ -553,30 +680,50 @@ func showDiff(w *nucular.Window, diff Diff) {
 				continue
 			}
 
+			off := int32(0)
+			text := func(s string) {
+				c.Text(s)
+				off += int32(len(s))
+			}
+			link := func(s string) bool {
+				off += int32(len(s))
+				return c.Link(s, color.RGBA{0x00, 0xaa, 0xff, 0xff}, nil)
+			}
+
 			c.SaveStyle()
 
-			if len(filediff.Headers) >= 0 {
+			if len(filediff.Headers) >= 2 {
 				c.SetStyle(richtext.TextStyle{Color: hunkhdrColor})
-				c.Text(filediff.Headers[1].Text)
-				c.Text(" ")
+				text(filediff.Headers[1].Text)
+				text(" ")
 				c.SetStyle(richtext.TextStyle{Color: color.RGBA{0x00, 0x88, 0xdd, 0xff}, Flags: richtext.Underline})
-				if c.Link("Blame", color.RGBA{0x00, 0xaa, 0xff, 0xff}, nil) {
+				if link("Blame") {
 					NewBlameWindow(w.Master(), "", filediff.Filename)
 				}
 				c.RestoreStyle()
-				c.Text("\n")
+				text("\n")
 			}
 
 			c.SetStyle(richtext.TextStyle{Color: hunkhdrColor})
-			for _, hdr := range filediff.Headers[2:] {
-				c.Text(hdr.Text)
-				c.Text("\n")
+			if len(filediff.Headers) >= 2 {
+				for _, hdr := range filediff.Headers[2:] {
+					text(hdr.Text)
+					text("\n")
+				}
+			}
+			for _, info := range filediff.Info {
+				text(info)
+				text("\n")
 			}
 			c.RestoreStyle()
 
-			c.Text("\n")
+			text("\n")
 
-			for _, linediff := range filediff.Lines {
+			filediff.lineOff = make([]int32, len(filediff.Lines)+1)
+			hunk := -1
+
+			for i, linediff := range filediff.Lines {
+				filediff.lineOff[i] = off
 				switch linediff.Opts {
 				case Addline:
 					c.ParagraphStyle(richtext.AlignLeftDumb, addlineBg)
@@ -596,16 +743,177 @@ func showDiff(w *nucular.Window, diff Diff) {
 						c.SetStyle(richtext.TextStyle{})
 					}
 
-					c.Text(chunk.Text)
+					text(chunk.Text)
+				}
+
+				if len(actions) > 0 && linediff.IsHunkHeader() {
+					hunk++
+					if hunk < len(filediff.Hunks) {
+						for _, action := range actions {
+							c.SetStyle(richtext.TextStyle{})
+							text("   ")
+							c.SetStyle(richtext.TextStyle{Color: color.RGBA{0x00, 0x88, 0xdd, 0xff}, Flags: richtext.Underline})
+							if link(action.name + " hunk") {
+								action.fn(filediff, hunk, filediff.hunkSelection(hunk, nil))
+							}
+							c.SetStyle(richtext.TextStyle{})
+							text(" ")
+							c.SetStyle(richtext.TextStyle{Color: color.RGBA{0x00, 0x88, 0xdd, 0xff}, Flags: richtext.Underline})
+							if link(action.name + " selected") {
+								sel := filediff.rtxt.Sel
+								action.fn(filediff, hunk, filediff.hunkSelection(hunk, &sel))
+							}
+						}
+					}
 				}
 
-				c.Text("\n")
+				text("\n")
 			}
+			filediff.lineOff[len(filediff.Lines)] = off
 
 			c.End()
 			w.TreePop()
 		}
 	}
+}
+
+func (fd *FileDiff) hunkEnd(hunk int) int {
+	if hunk+1 < len(fd.Hunks) {
+		return fd.Hunks[hunk+1].Start
+	}
+	return len(fd.Lines)
+}
+
+// hunkSelection returns, for each raw line of the hunk, whether it is
+// selected. If sel is nil all lines are selected, otherwise a line is
+// selected if it intersects sel or contains the cursor.
+func (fd *FileDiff) hunkSelection(hunk int, sel *richtext.Sel) []bool {
+	h := &fd.Hunks[hunk]
+	r := make([]bool, len(h.Lines))
+
+	// Lines reorders added and deleted lines when showing word differences
+	// but keeps the relative order of deleted lines and the relative order
+	// of added lines, so the n-th rendered deleted line is the n-th raw
+	// deleted line.
+	var raw [2][]int
+	for i, line := range h.Lines {
+		if len(line) == 0 {
+			continue
+		}
+		switch line[0] {
+		case '-':
+			raw[0] = append(raw[0], i)
+		case '+':
+			raw[1] = append(raw[1], i)
+		}
+	}
+
+	var cnt [2]int
+	for i := h.Start + 1; i < fd.hunkEnd(hunk); i++ {
+		k := 0
+		switch fd.Lines[i].Opts {
+		case Delline:
+			k = 0
+		case Addline:
+			k = 1
+		default:
+			continue
+		}
+		if cnt[k] >= len(raw[k]) {
+			continue
+		}
+		selected := sel == nil
+		if !selected && i+1 < len(fd.lineOff) {
+			s, e := fd.lineOff[i], fd.lineOff[i+1]
+			if sel.S == sel.E {
+				selected = sel.S >= s && sel.S < e
+			} else {
+				selected = sel.S < e && sel.E > s
+			}
+		}
+		r[raw[k][cnt[k]]] = selected
+		cnt[k]++
+	}
+	return r
+}
 
-	scrollingKeys(w, lnh)
+// hunkPatch returns a patch that contains only the selected lines of the
+// hunk. If reverse is set the patch is meant to be applied with -R.
+func (fd *FileDiff) hunkPatch(hunk int, sel []bool, reverse bool) (string, bool) {
+	h := &fd.Hunks[hunk]
+
+	oldstart, newstart := parseHunkRange(h.Header)
+	oldn, newn := 0, 0
+	any := false
+	kept := true
+	lines := []string{}
+
+	for i, line := range h.Lines {
+		if len(line) == 0 {
+			continue
+		}
+		switch line[0] {
+		case ' ':
+			lines = append(lines, line)
+			oldn++
+			newn++
+			kept = true
+		case '-', '+':
+			switch {
+			case sel[i]:
+				any = true
+				lines = append(lines, line)
+				if line[0] == '-' {
+					oldn++
+				} else {
+					newn++
+				}
+				kept = true
+			case (line[0] == '-') != reverse:
+				// the line is present on the side the patch will be applied to
+				lines = append(lines, " "+line[1:])
+				oldn++
+				newn++
+				kept = true
+			default:
+				kept = false
+			}
+		case '\\':
+			if kept {
+				lines = append(lines, line)
+			}
+		}
+	}
+
+	if !any {
+		return "", false
+	}
+
+	var buf bytes.Buffer
+	for _, hdr := range fd.Headers {
+		buf.WriteString(hdr.Text)
+		buf.WriteByte('\n')
+	}
+	fmt.Fprintf(&buf, "@@ -%d,%d +%d,%d @@\n", oldstart, oldn, newstart, newn)
+	for _, line := range lines {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	return buf.String(), true
+}
+
+// parseHunkRange returns the start lines of a hunk header
+func parseHunkRange(header string) (oldstart, newstart int) {
+	fields := strings.Fields(header)
+	if len(fields) < 3 {
+		return 0, 0
+	}
+	parse := func(s string) int {
+		if i := strings.Index(s, ","); i >= 0 {
+			s = s[:i]
+		}
+		n, _ := strconv.Atoi(s[1:])
+		return n
+	}
+	return parse(fields[1]), parse(fields[2])
 }
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+)
+
+const testHunkDiff = `diff --git a/a.txt b/a.txt
+index 1111111..2222222 100644
+--- a/a.txt
++++ b/a.txt
+@@ -1,4 +1,4 @@ func
+ one
+-two
+-three
++TWO
++THREE
+ four
+`
+
+func TestHunkPatch(t *testing.T) {
+	diff := parseDiff([]byte(testHunkDiff))
+	if len(diff) != 1 || len(diff[0].Hunks) != 1 {
+		t.Fatalf("wrong diff %#v", diff)
+	}
+	fd := &diff[0]
+	h := fd.Hunks[0]
+	if h.Header != "@@ -1,4 +1,4 @@ func" || len(h.Lines) != 6 {
+		t.Fatalf("wrong hunk %#v", h)
+	}
+
+	all := fd.hunkSelection(0, nil)
+	for i, tgt := range []bool{false, true, true, true, true, false} {
+		if all[i] != tgt {
+			t.Errorf("wrong selection of whole hunk: %v", all)
+		}
+	}
+
+	const hdr = "diff --git a/a.txt b/a.txt\nindex 1111111..2222222 100644\n--- a/a.txt\n+++ b/a.txt\n"
+
+	// stage the first deletion and the first addition
+	sel := []bool{false, true, false, true, false, false}
+	patch, ok := fd.hunkPatch(0, sel, false)
+	if tgt := hdr + "@@ -1,4 +1,4 @@\n one\n-two\n three\n+TWO\n four\n"; !ok || patch != tgt {
+		t.Errorf("wrong patch:\n%s", patch)
+	}
+
+	// unstage the same lines
+	patch, ok = fd.hunkPatch(0, sel, true)
+	if tgt := hdr + "@@ -1,4 +1,4 @@\n one\n-two\n+TWO\n THREE\n four\n"; !ok || patch != tgt {
+		t.Errorf("wrong reverse patch:\n%s", patch)
+	}
+
+	if _, ok := fd.hunkPatch(0, make([]bool, len(h.Lines)), false); ok {
+		t.Errorf("empty selection produced a patch")
+	}
+}
+
+func TestParseDiffNoBody(t *testing.T) {
+	const in = `diff --git a/b.bin b/b.bin
+index 88768ef..46befeb 100644
+Binary files a/b.bin and b/b.bin differ
+diff --git a/empty.txt b/empty.txt
+deleted file mode 100644
+index e69de29..0000000
+diff --git a/a.txt b/a.txt
+index 1111111..2222222 100644
+--- a/a.txt
++++ b/a.txt
+@@ -1 +1 @@
+
+-one
++two
+`
+	diff := parseDiff([]byte(in))
+	if len(diff) != 3 {
+		t.Fatalf("wrong number of files %d", len(diff))
+	}
+	for i, tgt := range []string{"b.bin (binary)", "empty.txt +0-0", "a.txt +1-1"} {
+		if diff[i].Filedescr != tgt {
+			t.Errorf("file %d: got %q expected %q", i, diff[i].Filedescr, tgt)
+		}
+	}
+	if !diff[0].Binary || diff[0].OldHash != "88768ef" || diff[0].NewHash != "46befeb" {
+		t.Errorf("wrong binary file %#v", diff[0])
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aarzilli/nucular"
+)
+
+// Before changes to the work tree are thrown away the affected files are
+// saved in a commit, the commits are kept alive by the reflog of
+// discardedRef. The parent of each commit is the HEAD at the time of the
+// snapshot, the message lists the saved paths.
+
+const discardedRef = privateRefsPrefix + "discarded"
+
+const emptyTreeId = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+type discardSnapshot struct {
+	Id     string
+	Parent string
+	When   time.Time
+	Descr  string
+	Paths  []string
+}
+
+// snapshotWorktree saves the work tree version of paths.
+func snapshotWorktree(descr string, paths ...string) error {
+	dir, err := ioutil.TempDir("", "fkgit-discard")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	env := append(os.Environ(),
+		"GIT_INDEX_FILE="+filepath.Join(dir, "index"),
+		"GIT_AUTHOR_NAME=fkgit", "GIT_AUTHOR_EMAIL=fkgit@localhost",
+		"GIT_COMMITTER_NAME=fkgit", "GIT_COMMITTER_EMAIL=fkgit@localhost")
+
+	run := func(args ...string) (string, error) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = Repodir
+		cmd.Env = env
+		bs, err := cmd.CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("git %s: %v\n%s", args[0], err, bs)
+		}
+		return strings.TrimSpace(string(bs)), nil
+	}
+
+	head, err := execCommand("git", "rev-parse", "-q", "--verify", "HEAD")
+	head = strings.TrimSpace(head)
+	if err == nil {
+		_, err = run("read-tree", head)
+	} else {
+		head = ""
+		_, err = run("read-tree", "--empty")
+	}
+	if err != nil {
+		return err
+	}
+
+	// paths are file names, not patterns
+	if _, err := run(append([]string{"--literal-pathspecs", "add", "-A", "-f", "--"}, paths...)...); err != nil {
+		return err
+	}
+	tree, err := run("write-tree")
+	if err != nil {
+		return err
+	}
+
+	args := []string{"commit-tree", tree, "-m", descr, "-m", strings.Join(paths, "\n")}
+	if head != "" {
+		args = append(args, "-p", head)
+	}
+	commit, err := run(args...)
+	if err != nil {
+		return err
+	}
+
+	out, err := runJob(editorCommand("git", "update-ref", "--create-reflog", "-m", descr, discardedRef, commit))
+	if err != nil {
+		return fmt.Errorf("git update-ref: %v\n%s", err, out)
+	}
+	return nil
+}
+
+// discardedSnapshots returns the most recent snapshots, newest first.
+func discardedSnapshots(n int) []discardSnapshot {
+	out, err := execCommand("git", "log", "-g", "-n", strconv.Itoa(n), "--format=%H%x00%P%x00%ct%x00%B%x01", discardedRef, "--")
+	if err != nil {
+		return nil
+	}
+	r := []discardSnapshot{}
+	for _, rec := range strings.Split(out, "\x01") {
+		v := strings.SplitN(strings.TrimLeft(rec, "\n"), "\x00", 4)
+		if len(v) != 4 {
+			continue
+		}
+		s := discardSnapshot{Id: v[0], Parent: v[1]}
+		if ts, err := strconv.ParseInt(v[2], 10, 64); err == nil {
+			s.When = time.Unix(ts, 0)
+		}
+		msg := strings.SplitN(strings.TrimSpace(v[3]), "\n\n", 2)
+		s.Descr = msg[0]
+		if len(msg) > 1 {
+			s.Paths = strings.Split(strings.TrimSpace(msg[1]), "\n")
+		}
+		r = append(r, s)
+	}
+	return r
+}
+
+// restore writes the saved paths back to the work tree, the index is left
+// untouched. Paths that did not exist when the snapshot was taken are
+// deleted.
+func (s *discardSnapshot) restore() (string, error) {
+	out, err := execCommand("git", append([]string{"--literal-pathspecs", "ls-tree", "-r", "-z", "--name-only", s.Id, "--"}, s.Paths...)...)
+	if err != nil {
+		return out, err
+	}
+	saved := strings.Split(out, "\x00")
+	isSaved := func(path string) bool {
+		// paths of directories are saved if any file inside them was
+		dir := strings.TrimSuffix(path, "/") + "/"
+		for _, p := range saved {
+			if p == path || strings.HasPrefix(p, dir) {
+				return true
+			}
+		}
+		return false
+	}
+	restore := []string{}
+	for _, path := range s.Paths {
+		if isSaved(path) {
+			restore = append(restore, path)
+			continue
+		}
+		if err := os.Remove(filepath.Join(Repodir, path)); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	if len(restore) == 0 {
+		return "", nil
+	}
+	return runJob(editorCommand("git", append([]string{"--literal-pathspecs", "restore", "--source=" + s.Id, "--worktree", "--"}, restore...)...))
+}
+
+type discardedTab struct {
+	mw        nucular.MasterWindow
+	snapshots []discardSnapshot
+	selected  int
+}
+
+func newDiscardedTab(mw nucular.MasterWindow) {
+	for _, tab := range tabs {
+		if dt, ok := tab.(*discardedTab); ok {
+			dt.reload()
+			currentTab = tabIndex(dt)
+			return
+		}
+	}
+	dt := &discardedTab{mw: mw}
+	dt.reload()
+	openTab(dt)
+}
+
+func (dt *discardedTab) reload() {
+	dt.snapshots = discardedSnapshots(50)
+	dt.selected = -1
+}
+
+func (dt *discardedTab) Title() string {
+	return "Recently discarded"
+}
+
+func (dt *discardedTab) Protected() bool {
+	return false
+}
+
+func (dt *discardedTab) Update(w *nucular.Window) {
+	w.Row(25).Static(0, 100, 100, 100)
+	w.Spacing(1)
+	if dt.selected >= 0 {
+		s := dt.snapshots[dt.selected]
+		if w.ButtonText("View") {
+			parent := s.Parent
+			if parent == "" {
+				parent = emptyTreeId
+			}
+			NewDiffWindow("HEAD", parent, "discarded", s.Id)
+		}
+		if w.ButtonText("Restore") {
+			go func() {
+				out, err := s.restore()
+				if err != nil && err != errJobCancelled {
+					newMessagePopup(dt.mw, "Error", fmt.Sprintf("Error: %v\n%s\n", err, out))
+				}
+				idxmw.reload()
+			}()
+		}
+	} else {
+		w.Spacing(2)
+	}
+	if w.ButtonText("Reload") {
+		dt.reload()
+	}
+
+	w.Row(0).Dynamic(1)
+	if sw := w.GroupBegin("discarded-list", nucular.WindowBorder|nucular.WindowNoHScrollbar); sw != nil {
+		sw.Row(20).Static(180, 0)
+		for i, s := range dt.snapshots {
+			selected := dt.selected == i
+			sw.SelectableLabel(s.When.Format("2006-01-02 15:04:05"), "LC", &selected)
+			sw.SelectableLabel(s.Descr, "LC", &selected)
+			if selected {
+				dt.selected = i
+			}
+		}
+		if len(dt.snapshots) == 0 {
+			sw.Row(20).Dynamic(1)
+			sw.Label("Nothing was discarded", "LC")
+		}
+		sw.GroupEnd()
+	}
+}
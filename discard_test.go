@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// testRepo creates a repository with a single commit containing a.txt and
+// sets Repodir to it.
+func testRepo(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "fkgit-test")
+	must(err)
+	Repodir = dir
+	testGit(t, "init", "-q")
+	testWrite(t, "a.txt", "one\n")
+	testGit(t, "add", "a.txt")
+	testGit(t, "commit", "-q", "-m", "first")
+	return dir
+}
+
+func testGit(t *testing.T, args ...string) string {
+	cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = Repodir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return string(out)
+}
+
+func testWrite(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(filepath.Join(Repodir, path), []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testRead(t *testing.T, path string) string {
+	bs, err := ioutil.ReadFile(filepath.Join(Repodir, path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bs)
+}
+
+func TestDiscardSnapshot(t *testing.T) {
+	dir := testRepo(t)
+	defer os.RemoveAll(dir)
+
+	testWrite(t, "a.txt", "two\n")
+	testWrite(t, "b.txt", "untracked\n")
+	if err := snapshotWorktree("Discard changes", "a.txt", "b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	testGit(t, "checkout", "-q", "--", "a.txt")
+	os.Remove(filepath.Join(dir, "b.txt"))
+
+	snapshots := discardedSnapshots(10)
+	if len(snapshots) != 1 || snapshots[0].Descr != "Discard changes" || len(snapshots[0].Paths) != 2 {
+		t.Fatalf("wrong snapshots %#v", snapshots)
+	}
+	if out, err := snapshots[0].restore(); err != nil {
+		t.Fatalf("restore: %v %s", err, out)
+	}
+	if a, b := testRead(t, "a.txt"), testRead(t, "b.txt"); a != "two\n" || b != "untracked\n" {
+		t.Errorf("wrong restored content %q %q", a, b)
+	}
+	if out := testGit(t, "diff", "--cached"); out != "" {
+		t.Errorf("restore changed the index:\n%s", out)
+	}
+}
+
+func TestDiscardSnapshotHidden(t *testing.T) {
+	dir := testRepo(t)
+	defer os.RemoveAll(dir)
+
+	testWrite(t, "a.txt", "two\n")
+	if err := snapshotWorktree("Discard changes", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	refs, err := allRefs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ref := range refs {
+		if ref.Name == discardedRef {
+			t.Errorf("snapshot reference listed: %#v", ref)
+		}
+	}
+	commits, err := allCommits(allRevs...).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 1 {
+		t.Errorf("wrong number of commits in the log %d", len(commits))
+	}
+}
+
+func TestDiscardSnapshotDeleted(t *testing.T) {
+	dir := testRepo(t)
+	defer os.RemoveAll(dir)
+
+	// the deletion of a.txt is discarded, [b].txt is a pattern matching
+	// b.txt
+	testWrite(t, "b.txt", "b\n")
+	testWrite(t, "[b].txt", "pattern\n")
+	testGit(t, "add", "b.txt", "[b].txt")
+	testGit(t, "commit", "-q", "-m", "second")
+	os.Remove(filepath.Join(dir, "a.txt"))
+	testWrite(t, "[b].txt", "changed\n")
+	if err := snapshotWorktree("Discard changes", "a.txt", "[b].txt"); err != nil {
+		t.Fatal(err)
+	}
+	testGit(t, "checkout", "-q", "--", "a.txt", "[b].txt")
+	testWrite(t, "b.txt", "changed b\n")
+
+	snapshots := discardedSnapshots(10)
+	if len(snapshots) != 1 {
+		t.Fatalf("wrong snapshots %#v", snapshots)
+	}
+	if out := testGit(t, "diff", "--name-only", "HEAD", snapshots[0].Id); out != "[b].txt\na.txt\n" {
+		t.Errorf("wrong paths saved:\n%s", out)
+	}
+	if out, err := snapshots[0].restore(); err != nil {
+		t.Fatalf("restore: %v %s", err, out)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.txt")); !os.IsNotExist(err) {
+		t.Errorf("deleted file was restored: %v", err)
+	}
+	if p, b := testRead(t, "[b].txt"), testRead(t, "b.txt"); p != "changed\n" || b != "changed b\n" {
+		t.Errorf("wrong restored content %q %q", p, b)
+	}
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aarzilli/nucular"
+	"github.com/aarzilli/nucular/rect"
+)
+
+// The editor server lets git commands started by fkgit use fkgit itself as
+// their editor: GIT_EDITOR and GIT_SEQUENCE_EDITOR are set to 'fkgit comed'
+// and 'fkgit seqed' respectively, which will connect to the editor socket
+// and wait for the user to finish editing the file inside fkgit.
+// Commit messages are edited in the commit tab, everything else is opened
+// in an editor tab.
+//
+// The same socket is used to ask the user for passwords: GIT_ASKPASS and
+// SSH_ASKPASS are set to fkgit itself and FKGIT_ASKPASS tells it to send an
+// "askpass" request with its argument as the prompt and print the answer.
+//
+// Each connection carries a single JSON encoded editorRequest followed by a
+// single JSON encoded editorResponse. If the client closes the connection
+// before receiving a response the edit is cancelled.
+
+const (
+	editorSocketEnv = "FKGIT_EDITOR_SOCKET"
+	askpassEnv      = "FKGIT_ASKPASS"
+)
+
+var editorSocket string
+
+type editorRequest struct {
+	Kind   string // "sequence", "message" or "askpass"
+	File   string
+	Prompt string // for "askpass"
+}
+
+type editorResponse struct {
+	Ok        bool
+	Cancelled bool
+	Error     string
+	Answer    string // for "askpass"
+}
+
+// editSession is an edit request waiting for the user.
+type editSession struct {
+	once       sync.Once
+	resp       editorResponse
+	done       chan struct{} // closed once resp is set
+	clientGone bool          // the client closed the connection before a response was sent
+}
+
+func newEditSession() *editSession {
+	return &editSession{done: make(chan struct{})}
+}
+
+// reply sets the response for this session, returns false if a response
+// was already sent.
+func (es *editSession) reply(resp editorResponse) bool {
+	first := false
+	es.once.Do(func() {
+		first = true
+		es.resp = resp
+		close(es.done)
+	})
+	return first
+}
+
+func (es *editSession) cancel() {
+	es.once.Do(func() {
+		es.resp = editorResponse{Cancelled: true}
+		es.clientGone = true
+		close(es.done)
+	})
+}
+
+// gone returns true if the client closed the connection without waiting for
+// a response.
+func (es *editSession) gone() bool {
+	select {
+	case <-es.done:
+		return es.clientGone
+	default:
+		return false
+	}
+}
+
+// editorSocketDir returns a directory only accessible by the current user
+// where the editor socket can be created.
+func editorSocketDir() (string, error) {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		dir = filepath.Join(dir, "fkgit")
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return "", err
+		}
+		return dir, os.Chmod(dir, 0700)
+	}
+	return ioutil.TempDir("", "fkgit")
+}
+
+func startEditorServer(mw nucular.MasterWindow) error {
+	dir, err := editorSocketDir()
+	if err != nil {
+		return fmt.Errorf("could not create editor socket directory: %v", err)
+	}
+	socname := filepath.Join(dir, fmt.Sprintf("editor.%d.%d", os.Getpid(), rand.Int()))
+	soc, err := net.Listen("unix", socname)
+	if err != nil {
+		return fmt.Errorf("could not bind editor socket: %v", err)
+	}
+	os.Chmod(socname, 0600)
+
+	editorSocket = socname
+	mw.OnClose(func() {
+		soc.Close()
+		os.Remove(socname)
+		os.Remove(dir)
+	})
+
+	go serveEditor(soc, func(req editorRequest, es *editSession) {
+		switch {
+		case req.Kind == "message" && isMessageFile(req.File):
+			editMessage(mw, req.File, es)
+		case req.Kind == "message" || req.Kind == "sequence":
+			editFile(mw, req.File, es)
+		case req.Kind == "askpass":
+			askPassword(mw, req.Prompt, es)
+		default:
+			es.reply(editorResponse{Error: fmt.Sprintf("unknown request %q", req.Kind)})
+		}
+	})
+	return nil
+}
+
+// editorCommand returns a command that will run in the repository directory
+// using fkgit as its editor and to ask for passwords.
+func editorCommand(cmdname string, args ...string) *exec.Cmd {
+	cmd := exec.Command(cmdname, args...)
+	cmd.Dir = Repodir
+	if editorSocket != "" {
+		exe, err := os.Executable()
+		if err != nil {
+			exe = "fkgit"
+		}
+		self := shellQuote(exe)
+		cmd.Env = append(os.Environ(), editorSocketEnv+"="+editorSocket, "GIT_SEQUENCE_EDITOR="+self+" seqed", "GIT_EDITOR="+self+" comed")
+		// askpass programs are executed without a shell
+		cmd.Env = append(cmd.Env, askpassEnv+"=1", "GIT_ASKPASS="+exe, "SSH_ASKPASS="+exe, "SSH_ASKPASS_REQUIRE=force")
+	}
+	return cmd
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// serveEditor accepts connections on soc and calls handle for each request
+// it receives, handle must eventually call reply on the edit session,
+// possibly from a different goroutine.
+func serveEditor(soc net.Listener, handle func(req editorRequest, es *editSession)) {
+	for {
+		conn, err := soc.Accept()
+		if err != nil {
+			return
+		}
+		go serveEditorConn(conn, handle)
+	}
+}
+
+func serveEditorConn(conn net.Conn, handle func(req editorRequest, es *editSession)) {
+	defer conn.Close()
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+
+	var req editorRequest
+	if err := dec.Decode(&req); err != nil {
+		enc.Encode(editorResponse{Error: fmt.Sprintf("could not read request: %v", err)})
+		return
+	}
+
+	es := newEditSession()
+
+	go func() {
+		// the client never sends anything after the request, anything it
+		// does send is discarded until the connection is closed.
+		var buf [64]byte
+		for {
+			if _, err := conn.Read(buf[:]); err != nil {
+				break
+			}
+		}
+		es.cancel()
+	}()
+
+	handle(req, es)
+
+	<-es.done
+	enc.Encode(es.resp)
+}
+
+func sendEditorRequest(socname string, req editorRequest) (editorResponse, error) {
+	var resp editorResponse
+	conn, err := net.Dial("unix", socname)
+	if err != nil {
+		return resp, err
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return resp, err
+	}
+	err = json.NewDecoder(conn).Decode(&resp)
+	return resp, err
+}
+
+func isMessageFile(filename string) bool {
+	name := filepath.Base(filename)
+	return strings.HasSuffix(name, "_EDITMSG") || strings.HasSuffix(name, "_MSG")
+}
+
+// messageEditSem is held while the commit tab is editing a message for an
+// editor request, other message edits will wait for it.
+var messageEditSem = make(chan struct{}, 1)
+
+// editMessage shows filename in the commit tab.
+func editMessage(mw nucular.MasterWindow, filename string, es *editSession) {
+	select {
+	case messageEditSem <- struct{}{}:
+	case <-es.done:
+		return
+	}
+
+	go func() {
+		<-es.done
+		if es.clientGone {
+			mw.Lock()
+			idxmw.mu.Lock()
+			if idxmw.editSession == es {
+				idxmw.clearEditMessage()
+			}
+			idxmw.mu.Unlock()
+			mw.Unlock()
+			mw.Changed()
+		}
+		<-messageEditSem
+	}()
+
+	mw.Lock()
+	defer mw.Unlock()
+	if es.gone() {
+		return
+	}
+	idxmw.mu.Lock()
+	idxmw.editmsg = filename
+	idxmw.editSession = es
+	idxmw.editReturnTab = tabs[currentTab]
+	idxmw.mu.Unlock()
+	if i := tabIndex(&idxmw); i >= 0 {
+		currentTab = i
+	}
+	idxmw.reload()
+	mw.Changed()
+}
+
+// editFile opens filename in a new editor tab.
+func editFile(mw nucular.MasterWindow, filename string, es *editSession) {
+	et := &editorTab{filename: filename, es: es}
+	et.ed.Flags = nucular.EditSelectable | nucular.EditMultiline | nucular.EditFocusFollowsMouse | nucular.EditClipboard
+	bs, err := ioutil.ReadFile(filename)
+	if err != nil {
+		es.reply(editorResponse{Error: fmt.Sprintf("could not load %s: %v", filename, err)})
+		return
+	}
+	et.ed.Buffer = []rune(string(bs))
+
+	go func() {
+		<-es.done
+		if !es.clientGone {
+			return
+		}
+		mw.Lock()
+		defer mw.Unlock()
+		if !et.closed {
+			et.closed = true
+			closeTab(et)
+		}
+		mw.Changed()
+	}()
+
+	mw.Lock()
+	defer mw.Unlock()
+	if es.gone() {
+		return
+	}
+	et.returnTab = tabs[currentTab]
+	openTab(et)
+	mw.Changed()
+}
+
+type editorTab struct {
+	filename  string
+	ed        nucular.TextEditor
+	es        *editSession
+	closed    bool
+	returnTab Tab
+}
+
+func (et *editorTab) Title() string {
+	return "Edit " + filepath.Base(et.filename)
+}
+
+func (et *editorTab) Protected() bool {
+	return !et.closed
+}
+
+func (et *editorTab) Update(w *nucular.Window) {
+	w.LayoutReserveRow(25, 1)
+	w.Row(0).Dynamic(1)
+	et.ed.Edit(w)
+
+	w.Row(25).Static(0, 100, 100)
+	w.Spacing(1)
+	if w.ButtonText("Ok") {
+		err := ioutil.WriteFile(et.filename, []byte(string(et.ed.Buffer)), 0666)
+		if err != nil {
+			et.close(editorResponse{Error: fmt.Sprintf("could not write %s: %v", et.filename, err)})
+		} else {
+			et.close(editorResponse{Ok: true})
+		}
+	}
+	if w.ButtonText("Cancel") {
+		et.close(editorResponse{Cancelled: true})
+	}
+}
+
+func (et *editorTab) close(resp editorResponse) {
+	et.es.reply(resp)
+	et.closed = true
+	closeTab(et)
+	if i := tabIndex(et.returnTab); i >= 0 {
+		currentTab = i
+	}
+}
+
+func editmodeMain() {
+	if len(os.Args) < 3 {
+		fmt.Fprintf(os.Stderr, "no file to edit\n")
+		os.Exit(1)
+	}
+	req := editorRequest{Kind: "message", File: os.Args[2]}
+	if os.Args[1] == "seqed" {
+		req.Kind = "sequence"
+	}
+	if abs, err := filepath.Abs(req.File); err == nil {
+		req.File = abs
+	}
+	resp, err := sendEditorRequest(os.Getenv(editorSocketEnv), req)
+	switch {
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "could not talk to fkgit: %v\n", err)
+		os.Exit(1)
+	case resp.Error != "":
+		fmt.Fprintf(os.Stderr, "%s\n", resp.Error)
+		os.Exit(1)
+	case resp.Cancelled || !resp.Ok:
+		fmt.Fprintf(os.Stderr, "edit of %s cancelled\n", req.File)
+		os.Exit(1)
+	}
+}
+
+// askpassSem is held while a password is being asked, other requests will
+// wait for it.
+var askpassSem = make(chan struct{}, 1)
+
+// askpassMasked returns true if the answer to prompt should not be shown.
+func askpassMasked(prompt string) bool {
+	return !strings.HasPrefix(prompt, "Username") && !strings.Contains(prompt, "(yes/no")
+}
+
+// askPassword shows prompt in a popup and replies with what the user types.
+func askPassword(mw nucular.MasterWindow, prompt string, es *editSession) {
+	select {
+	case askpassSem <- struct{}{}:
+	case <-es.done:
+		return
+	}
+	go func() {
+		<-es.done
+		<-askpassSem
+		mw.Changed()
+	}()
+
+	var ed nucular.TextEditor
+	ed.Flags = nucular.EditSigEnter | nucular.EditSelectable | nucular.EditClipboard
+	if askpassMasked(prompt) {
+		ed.PasswordChar = '*'
+	}
+	ed.Active = true
+
+	mw.Lock()
+	defer mw.Unlock()
+	if es.gone() {
+		return
+	}
+	mw.PopupOpen("Authentication", popupFlags, rect.Rect{20, 100, 480, 200}, true, func(w *nucular.Window) {
+		if es.gone() {
+			w.Close()
+			return
+		}
+		w.Row(25).Dynamic(1)
+		w.Label(strings.TrimSpace(prompt), "LC")
+		ed.Edit(w)
+		ok, cancel := okCancelButtons(w, !ed.Active, "OK", true)
+		switch {
+		case ok:
+			es.reply(editorResponse{Ok: true, Answer: string(ed.Buffer)})
+		case cancel:
+			es.reply(editorResponse{Cancelled: true})
+		}
+	})
+	mw.Changed()
+}
+
+func askpassMain() {
+	prompt := ""
+	if len(os.Args) >= 2 {
+		prompt = os.Args[1]
+	}
+	resp, err := sendEditorRequest(os.Getenv(editorSocketEnv), editorRequest{Kind: "askpass", Prompt: prompt})
+	switch {
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "could not talk to fkgit: %v\n", err)
+		os.Exit(1)
+	case resp.Error != "":
+		fmt.Fprintf(os.Stderr, "%s\n", resp.Error)
+		os.Exit(1)
+	case resp.Cancelled || !resp.Ok:
+		os.Exit(1)
+	}
+	fmt.Println(resp.Answer)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// the test binary is used as the editor of the commands started by
+	// editorCommand
+	if len(os.Args) >= 2 && (os.Args[1] == "seqed" || os.Args[1] == "comed") && os.Getenv(editorSocketEnv) != "" {
+		editmodeMain()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestEditorProtocol(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fkgit-test")
+	must(err)
+	defer os.RemoveAll(dir)
+
+	t.Setenv("XDG_RUNTIME_DIR", dir)
+	sockdir, err := editorSocketDir()
+	must(err)
+	fi, err := os.Stat(sockdir)
+	must(err)
+	if fi.Mode().Perm() != 0700 {
+		t.Errorf("wrong permissions for socket directory %s: %v", sockdir, fi.Mode().Perm())
+	}
+
+	socname := filepath.Join(sockdir, "editor.test")
+	soc, err := net.Listen("unix", socname)
+	must(err)
+	defer soc.Close()
+
+	gone := make(chan bool, 3)
+
+	go serveEditor(soc, func(req editorRequest, es *editSession) {
+		switch req.File {
+		case "ok":
+			es.reply(editorResponse{Ok: true})
+		case "error":
+			es.reply(editorResponse{Error: "some error"})
+		case "later":
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				es.reply(editorResponse{Ok: true})
+			}()
+		case "wait":
+			go func() {
+				<-es.done
+				gone <- es.clientGone
+			}()
+		}
+	})
+
+	resp, err := sendEditorRequest(socname, editorRequest{Kind: "message", File: "ok"})
+	if err != nil || !resp.Ok {
+		t.Errorf("unexpected response %#v %v", resp, err)
+	}
+
+	resp, err = sendEditorRequest(socname, editorRequest{Kind: "message", File: "error"})
+	if err != nil || resp.Ok || resp.Error != "some error" {
+		t.Errorf("unexpected response %#v %v", resp, err)
+	}
+
+	// data sent after the request does not cancel it
+	conn, err := net.Dial("unix", socname)
+	must(err)
+	conn.Write([]byte(`{"Kind":"message","File":"later"}` + "\nextra data"))
+	resp = editorResponse{}
+	err = json.NewDecoder(conn).Decode(&resp)
+	conn.Close()
+	if err != nil || !resp.Ok {
+		t.Errorf("unexpected response %#v %v", resp, err)
+	}
+
+	// several requests waiting at the same time, the client going away
+	// cancels them
+	const n = 3
+	conns := make([]net.Conn, n)
+	for i := range conns {
+		conns[i], err = net.Dial("unix", socname)
+		must(err)
+		conns[i].Write([]byte(`{"Kind":"message","File":"wait"}` + "\n"))
+	}
+	time.Sleep(10 * time.Millisecond)
+	for i := range conns {
+		conns[i].Close()
+	}
+	for range conns {
+		select {
+		case clientGone := <-gone:
+			if !clientGone {
+				t.Errorf("request was not cancelled")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for cancellation")
+		}
+	}
+}
+
+func TestAskpass(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fkgit-test")
+	must(err)
+	defer os.RemoveAll(dir)
+
+	socname := filepath.Join(dir, "editor.test")
+	soc, err := net.Listen("unix", socname)
+	must(err)
+	defer soc.Close()
+
+	go serveEditor(soc, func(req editorRequest, es *editSession) {
+		if req.Kind != "askpass" {
+			es.reply(editorResponse{Error: "wrong kind " + req.Kind})
+			return
+		}
+		if req.Prompt == "cancel" {
+			es.reply(editorResponse{Cancelled: true})
+			return
+		}
+		es.reply(editorResponse{Ok: true, Answer: "answer to " + req.Prompt})
+	})
+
+	resp, err := sendEditorRequest(socname, editorRequest{Kind: "askpass", Prompt: "Password for 'https://example.com': "})
+	if err != nil || !resp.Ok || resp.Answer != "answer to Password for 'https://example.com': " {
+		t.Errorf("unexpected response %#v %v", resp, err)
+	}
+	resp, err = sendEditorRequest(socname, editorRequest{Kind: "askpass", Prompt: "cancel"})
+	if err != nil || resp.Ok || !resp.Cancelled {
+		t.Errorf("unexpected response %#v %v", resp, err)
+	}
+
+	for prompt, masked := range map[string]bool{
+		"Username for 'https://example.com': ":                                  false,
+		"Password for 'https://user@example.com': ":                             true,
+		"Enter passphrase for key '/home/user/.ssh/id_ed25519': ":               true,
+		"Are you sure you want to continue connecting (yes/no/[fingerprint])? ": false,
+	} {
+		if askpassMasked(prompt) != masked {
+			t.Errorf("%q: wrong masking", prompt)
+		}
+	}
+}
+
+func TestEditorCommand(t *testing.T) {
+	dir := testRepo(t)
+	defer os.RemoveAll(dir)
+
+	socname := filepath.Join(dir, ".git", "editor.test")
+	soc, err := net.Listen("unix", socname)
+	must(err)
+	defer soc.Close()
+	editorSocket = socname
+	defer func() { editorSocket = "" }()
+
+	reqs := make(chan editorRequest, 10)
+	go serveEditor(soc, func(req editorRequest, es *editSession) {
+		reqs <- req
+		bs, err := ioutil.ReadFile(req.File)
+		if err != nil {
+			es.reply(editorResponse{Error: err.Error()})
+			return
+		}
+		switch {
+		case req.Kind == "message" && isMessageFile(req.File) && strings.Contains(string(bs), "# Please enter"):
+			if strings.Contains(string(bs), "cancel me") {
+				es.reply(editorResponse{Cancelled: true})
+				return
+			}
+			must(ioutil.WriteFile(req.File, []byte("edited message\n"), 0666))
+		case req.Kind == "sequence":
+			must(ioutil.WriteFile(req.File, []byte(strings.Replace(string(bs), "pick ", "drop ", -1)), 0666))
+		default:
+			es.reply(editorResponse{Error: "unexpected request"})
+			return
+		}
+		es.reply(editorResponse{Ok: true})
+	})
+
+	run := func(args ...string) error {
+		cmd := editorCommand("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Logf("git %v: %v\n%s", args, err, out)
+		}
+		return err
+	}
+	checkKind := func(kind string) {
+		select {
+		case req := <-reqs:
+			if req.Kind != kind || !filepath.IsAbs(req.File) {
+				t.Errorf("unexpected request %#v, expected %q", req, kind)
+			}
+		default:
+			t.Errorf("no %q request", kind)
+		}
+	}
+
+	if err := run("commit", "-q", "--allow-empty", "-m", "original", "-e"); err != nil {
+		t.Fatal(err)
+	}
+	checkKind("message")
+	if msg := strings.TrimSpace(testGit(t, "log", "-1", "--format=%s")); msg != "edited message" {
+		t.Errorf("wrong commit message %q", msg)
+	}
+
+	if err := run("commit", "-q", "--allow-empty", "-m", "cancel me", "-e"); err == nil {
+		t.Errorf("cancelled edit did not fail the commit")
+	}
+	checkKind("message")
+	if n := strings.TrimSpace(testGit(t, "rev-list", "--count", "HEAD")); n != "2" {
+		t.Errorf("wrong number of commits after cancelled edit: %s", n)
+	}
+
+	if err := run("rebase", "-q", "-i", "HEAD~1"); err != nil {
+		t.Fatal(err)
+	}
+	checkKind("sequence")
+	if msg := strings.TrimSpace(testGit(t, "log", "-1", "--format=%s")); msg != "first" {
+		t.Errorf("commit was not dropped by the sequence editor, HEAD is %q", msg)
+	}
+}
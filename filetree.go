@@ -0,0 +1,151 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// fileTreeNode is a file or a directory in the tree of changed files shown
+// by the Commit tab.
+type fileTreeNode struct {
+	Name     string
+	Path     string // directories end with "/"
+	Line     int    // index in GitStatus.Lines, -1 for directories
+	Children []*fileTreeNode
+
+	// for directories, number of changed files under it (ignored files are
+	// not counted) and how many of them are completely or partially staged
+	Count, Staged, Partial int
+}
+
+type fileTreeRow struct {
+	node  *fileTreeNode
+	depth int
+}
+
+type statusFilter struct {
+	name string
+	hide [numStatusCategories]bool
+}
+
+func (filter *statusFilter) match(line *StatusLine) bool {
+	if filter.hide[line.Category()] {
+		return false
+	}
+	return filter.name == "" || strings.Contains(strings.ToLower(line.Path), strings.ToLower(filter.name))
+}
+
+// buildFileTree returns the tree of the lines that match filter, the
+// returned node is the root directory.
+func buildFileTree(lines []StatusLine, filter *statusFilter) *fileTreeNode {
+	root := &fileTreeNode{Line: -1}
+	dirs := map[string]*fileTreeNode{"": root}
+
+	var dirOf func(path string) *fileTreeNode
+	dirOf = func(path string) *fileTreeNode {
+		if n := dirs[path]; n != nil {
+			return n
+		}
+		parent, name := "", strings.TrimSuffix(path, "/")
+		if i := strings.LastIndex(name, "/"); i >= 0 {
+			parent, name = path[:i+1], name[i+1:]
+		}
+		n := &fileTreeNode{Name: name, Path: path, Line: -1}
+		p := dirOf(parent)
+		p.Children = append(p.Children, n)
+		dirs[path] = n
+		return n
+	}
+
+	for i := range lines {
+		line := &lines[i]
+		if !filter.match(line) {
+			continue
+		}
+		// untracked directories are shown as a single file
+		dir, name := "", strings.TrimSuffix(line.Path, "/")
+		if j := strings.LastIndex(name, "/"); j >= 0 {
+			dir = line.Path[:j+1]
+		}
+		name = line.Path[len(dir):]
+		p := dirOf(dir)
+		p.Children = append(p.Children, &fileTreeNode{Name: name, Path: line.Path, Line: i})
+	}
+
+	root.count(lines)
+	root.sort()
+	return root
+}
+
+func (n *fileTreeNode) count(lines []StatusLine) {
+	n.Count, n.Staged, n.Partial = 0, 0, 0
+	for _, child := range n.Children {
+		if child.Line >= 0 {
+			line := &lines[child.Line]
+			if line.Index == "!" {
+				// ignored files can not be staged
+				continue
+			}
+			n.Count++
+			switch {
+			case line.PartiallyStaged():
+				n.Partial++
+			case line.Index != " " && line.WorkDir == " ":
+				n.Staged++
+			}
+			continue
+		}
+		child.count(lines)
+		n.Count += child.Count
+		n.Staged += child.Staged
+		n.Partial += child.Partial
+	}
+}
+
+// sort sorts directories before files, by name.
+func (n *fileTreeNode) sort() {
+	sort.SliceStable(n.Children, func(i, j int) bool {
+		a, b := n.Children[i], n.Children[j]
+		if (a.Line < 0) != (b.Line < 0) {
+			return a.Line < 0
+		}
+		return a.Name < b.Name
+	})
+	for _, child := range n.Children {
+		child.sort()
+	}
+}
+
+// rows returns the visible rows of the children of n, the contents of
+// directories in collapsed are not visible.
+func (n *fileTreeNode) rows(collapsed map[string]bool, depth int, out []fileTreeRow) []fileTreeRow {
+	for _, child := range n.Children {
+		out = append(out, fileTreeRow{child, depth})
+		if child.Line < 0 && !collapsed[child.Path] {
+			out = child.rows(collapsed, depth+1, out)
+		}
+	}
+	return out
+}
+
+// lines returns the indexes of all lines under n.
+func (n *fileTreeNode) lines(out []int) []int {
+	if n.Line >= 0 {
+		return append(out, n.Line)
+	}
+	for _, child := range n.Children {
+		out = child.lines(out)
+	}
+	return out
+}
+
+// flatFileRows returns one row for each line matching filter.
+func flatFileRows(lines []StatusLine, filter *statusFilter) []fileTreeRow {
+	r := []fileTreeRow{}
+	for i := range lines {
+		if filter.match(&lines[i]) {
+			r = append(r, fileTreeRow{&fileTreeNode{Name: lines[i].Path, Path: lines[i].Path, Line: i}, 0})
+		}
+	}
+	return r
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestBuildFileTree(t *testing.T) {
+	lines := []StatusLine{
+		{Index: "M", WorkDir: " ", Path: "a/b/c.go"},
+		{Index: " ", WorkDir: "M", Path: "a/b/d.go"},
+		{Index: "M", WorkDir: "M", Path: "a/e.go"},
+		{Index: "?", WorkDir: "?", Path: "a/new/"},
+		{Index: " ", WorkDir: "D", Path: "main.go"},
+		{Index: "U", WorkDir: "U", Path: "z/x.go"},
+	}
+
+	dump := func(rows []fileTreeRow) string {
+		var buf strings.Builder
+		for _, row := range rows {
+			fmt.Fprintf(&buf, "%s%s", strings.Repeat("  ", row.depth), row.node.Name)
+			if row.node.Line < 0 {
+				fmt.Fprintf(&buf, " %d/%d/%d", row.node.Count, row.node.Staged, row.node.Partial)
+			}
+			buf.WriteString("\n")
+		}
+		return buf.String()
+	}
+
+	tree := buildFileTree(lines, &statusFilter{})
+	tgt := `a 4/1/1
+  b 2/1/0
+    c.go
+    d.go
+  e.go
+  new/
+z 1/0/0
+  x.go
+main.go
+`
+	if out := dump(tree.rows(map[string]bool{}, 0, nil)); out != tgt {
+		t.Errorf("tree mismatch:\n%s\nexpected:\n%s", out, tgt)
+	}
+
+	tgt = `a 4/1/1
+  b 2/1/0
+  e.go
+  new/
+z 1/0/0
+main.go
+`
+	if out := dump(tree.rows(map[string]bool{"a/b/": true, "z/": true}, 0, nil)); out != tgt {
+		t.Errorf("collapsed tree mismatch:\n%s\nexpected:\n%s", out, tgt)
+	}
+
+	if got := fmt.Sprint(tree.Children[0].lines(nil)); got != "[0 1 2 3]" {
+		t.Errorf("lines of a/: %s", got)
+	}
+
+	filter := &statusFilter{name: "D.GO"}
+	filter.hide[categoryConflicted] = true
+	tgt = `a 1/0/0
+  b 1/0/0
+    d.go
+`
+	if out := dump(buildFileTree(lines, filter).rows(map[string]bool{}, 0, nil)); out != tgt {
+		t.Errorf("filtered tree mismatch:\n%s\nexpected:\n%s", out, tgt)
+	}
+
+	filter = &statusFilter{}
+	filter.hide[categoryModified] = true
+	filter.hide[categoryUntracked] = true
+	if out := dump(flatFileRows(lines, filter)); out != "main.go\nz/x.go\n" {
+		t.Errorf("flat rows mismatch:\n%s", out)
+	}
+}
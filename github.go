@@ -36,7 +36,7 @@ func parseGithubRemote(remote string) (owner, repo string) {
 
 func initGithubIntegration(mw nucular.MasterWindow) {
 	remotes := allRemotes()
-	owner, repo := parseGithubRemote(remotes["origin"])
+	owner, repo := parseGithubRemote(remotes["origin"].FetchURL)
 	if repo == "" {
 		return
 	}
@@ -156,10 +156,10 @@ func (gs *GithubStuff) reload() {
 	gs.mw.Changed()
 }
 
-func githubRemoteRef(refs []Ref, allRemotes map[string]string) *Ref {
+func githubRemoteRef(refs []Ref, allRemotes map[string]Remote) *Ref {
 	for i := range refs {
 		ref := &refs[i]
-		_, repo := parseGithubRemote(allRemotes[ref.Remote()])
+		_, repo := parseGithubRemote(allRemotes[ref.Remote()].FetchURL)
 		if repo != "" {
 			return ref
 		}
@@ -173,7 +173,7 @@ func (gs *GithubStuff) pullRequest(lw *LogWindow, title string, ref *Ref, lc Lan
 
 	remotes := allRemotes()
 
-	owner, repo := parseGithubRemote(remotes[ref.Remote()])
+	owner, repo := parseGithubRemote(remotes[ref.Remote()].FetchURL)
 
 	commits, err := allCommits("origin^1..HEAD").ReadAll()
 	if err != nil {
@@ -188,7 +188,7 @@ func (gs *GithubStuff) pullRequest(lw *LogWindow, title string, ref *Ref, lc Lan
 	for i := range lw.allrefs {
 		ref := &lw.allrefs[i]
 		if ref.CommitId == originCommit.Id {
-			originOwner, originRepo = parseGithubRemote(remotes[ref.Remote()])
+			originOwner, originRepo = parseGithubRemote(remotes[ref.Remote()].FetchURL)
 			if originRepo != "" && originOwner == gs.owner && originRepo == gs.repo && ref.Nice() != "origin/HEAD" {
 				originRef = ref
 				break
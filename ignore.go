@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aarzilli/nucular"
+	"github.com/aarzilli/nucular/rect"
+)
+
+// ignoreTarget is a file where ignore patterns can be written.
+type ignoreTarget struct {
+	Descr string
+	File  string // relative to Repodir
+	Base  string // directory the patterns are relative to, "" or ending in "/"
+}
+
+// ignoreTargets returns the files where a pattern ignoring p can be added:
+// the nearest .gitignore, the .gitignore at the root of the repository and
+// .git/info/exclude.
+func ignoreTargets(p string) []ignoreTarget {
+	r := []ignoreTarget{}
+
+	dir := path.Dir(strings.TrimSuffix(p, "/"))
+	if dir != "." {
+		nearest := dir
+		for d := dir; d != "."; d = path.Dir(d) {
+			if _, err := os.Stat(filepath.Join(Repodir, d, ".gitignore")); err == nil {
+				nearest = d
+				break
+			}
+		}
+		r = append(r, ignoreTarget{Descr: nearest + "/.gitignore", File: nearest + "/.gitignore", Base: nearest + "/"})
+	}
+
+	r = append(r, ignoreTarget{Descr: ".gitignore", File: ".gitignore"})
+	r = append(r, ignoreTarget{Descr: ".git/info/exclude (not shared)", File: ".git/info/exclude"})
+	return r
+}
+
+// ignorePatterns returns the patterns that can be used to ignore p in a
+// file of patterns relative to base: the exact path, all files with the
+// same extension and the containing directory.
+func ignorePatterns(p, base string) []string {
+	rel := strings.TrimPrefix(p, base)
+	r := []string{"/" + escapeIgnorePattern(rel)}
+	name := path.Base(strings.TrimSuffix(rel, "/"))
+	if ext := path.Ext(name); ext != "" && ext != name && !strings.HasSuffix(rel, "/") {
+		r = append(r, "*"+escapeIgnorePattern(ext))
+	}
+	if dir := path.Dir(strings.TrimSuffix(rel, "/")); dir != "." {
+		r = append(r, "/"+escapeIgnorePattern(dir)+"/")
+	}
+	return r
+}
+
+func escapeIgnorePattern(s string) string {
+	var buf strings.Builder
+	for i, ch := range s {
+		switch ch {
+		case '*', '?', '[', '\\':
+			buf.WriteByte('\\')
+		case '!', '#':
+			if i == 0 {
+				buf.WriteByte('\\')
+			}
+		}
+		buf.WriteRune(ch)
+	}
+	if strings.HasSuffix(s, " ") {
+		return strings.TrimSuffix(buf.String(), " ") + "\\ "
+	}
+	return buf.String()
+}
+
+// rootIgnorePattern converts a pattern relative to base into a pattern
+// relative to the root of the repository.
+func rootIgnorePattern(pattern, base string) string {
+	if base == "" {
+		return pattern
+	}
+	if strings.HasPrefix(pattern, "/") {
+		return "/" + base + pattern[1:]
+	}
+	return "/" + base + "**/" + pattern
+}
+
+// hiddenByPattern returns the untracked files that would be ignored by
+// adding pattern to a file of patterns relative to base.
+func hiddenByPattern(pattern, base string) ([]string, error) {
+	before, err := execCommand("git", "ls-files", "-z", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, fmt.Errorf("git ls-files: %v\n%s", err, before)
+	}
+	after, err := execCommand("git", "ls-files", "-z", "--others", "--exclude-standard", "--exclude="+rootIgnorePattern(pattern, base))
+	if err != nil {
+		return nil, fmt.Errorf("git ls-files: %v\n%s", err, after)
+	}
+	visible := map[string]bool{}
+	for _, p := range strings.Split(after, "\x00") {
+		visible[p] = true
+	}
+	r := []string{}
+	for _, p := range strings.Split(before, "\x00") {
+		if p != "" && !visible[p] {
+			r = append(r, p)
+		}
+	}
+	return r, nil
+}
+
+// appendIgnorePattern adds pattern at the end of file, creating it if
+// needed.
+func appendIgnorePattern(file, pattern string) error {
+	file = filepath.Join(Repodir, file)
+	if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+		return err
+	}
+	bs, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	fh, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	if len(bs) > 0 && bs[len(bs)-1] != '\n' {
+		fmt.Fprintf(fh, "\n")
+	}
+	_, err = fmt.Fprintf(fh, "%s\n", pattern)
+	return err
+}
+
+// ignoreRules returns, for each of paths, a description of the rule
+// that ignores it.
+func ignoreRules(paths []string) map[string]string {
+	r := map[string]string{}
+	if len(paths) == 0 {
+		return r
+	}
+	out, _ := execCommandStdin(strings.Join(paths, "\x00")+"\x00", "git", "check-ignore", "-v", "-z", "--stdin")
+	v := strings.Split(out, "\x00")
+	for i := 0; i+3 < len(v); i += 4 {
+		source, line, pattern, p := v[i], v[i+1], v[i+2], v[i+3]
+		if source == "" {
+			continue
+		}
+		if rel, err := filepath.Rel(Repodir, source); err == nil && !strings.HasPrefix(rel, "..") {
+			source = rel
+		}
+		r[p] = fmt.Sprintf("%s:%s: %s", source, line, pattern)
+	}
+	return r
+}
+
+type ignorePopup struct {
+	mw      nucular.MasterWindow
+	path    string
+	targets []ignoreTarget
+	target  int
+	pattern int
+
+	// preview of the files hidden by the selected pattern
+	previewFor string
+	hidden     []string
+	err        error
+}
+
+func newIgnorePopup(mw nucular.MasterWindow, path string) {
+	ip := &ignorePopup{mw: mw, path: path, targets: ignoreTargets(path)}
+	mw.PopupOpen("Ignore...", popupFlags, rect.Rect{20, 100, 480, 500}, true, ip.Update)
+}
+
+func (ip *ignorePopup) Update(w *nucular.Window) {
+	w.Row(25).Dynamic(1)
+	w.Label(fmt.Sprintf("Ignore %s", ip.path), "LC")
+
+	w.Row(20).Dynamic(1)
+	w.Label("Add the pattern to:", "LC")
+	for i := range ip.targets {
+		if w.OptionText(ip.targets[i].Descr, ip.target == i) {
+			ip.target = i
+		}
+	}
+
+	target := &ip.targets[ip.target]
+	patterns := ignorePatterns(ip.path, target.Base)
+	if ip.pattern >= len(patterns) {
+		ip.pattern = 0
+	}
+	w.Label("Pattern:", "LC")
+	for i := range patterns {
+		if w.OptionText(patterns[i], ip.pattern == i) {
+			ip.pattern = i
+		}
+	}
+	pattern := patterns[ip.pattern]
+
+	if key := target.Base + "\x00" + pattern; ip.previewFor != key {
+		ip.previewFor = key
+		ip.hidden, ip.err = nil, nil
+		go func() {
+			hidden, err := hiddenByPattern(pattern, target.Base)
+			ip.mw.Lock()
+			defer ip.mw.Unlock()
+			if ip.previewFor == key {
+				ip.hidden, ip.err = hidden, err
+			}
+			ip.mw.Changed()
+		}()
+	}
+
+	switch {
+	case ip.err != nil:
+		w.Label(strings.SplitN(ip.err.Error(), "\n", 2)[0], "LC")
+	case ip.hidden == nil:
+		w.Label("Files that will be hidden: loading...", "LC")
+	default:
+		w.Label(fmt.Sprintf("Files that will be hidden (%d):", len(ip.hidden)), "LC")
+	}
+	w.Row(150).Dynamic(1)
+	if sw := w.GroupBegin("ignore-preview", nucular.WindowBorder); sw != nil {
+		sw.Row(20).Dynamic(1)
+		for _, p := range ip.hidden {
+			sw.Label(p, "LC")
+		}
+		sw.GroupEnd()
+	}
+
+	ok, _ := okCancelButtons(w, true, "Ignore", true)
+	if ok {
+		if err := appendIgnorePattern(target.File, pattern); err != nil {
+			newMessagePopup(ip.mw, "Error", fmt.Sprintf("Error: %v\n", err))
+		}
+		idxmw.reload()
+	}
+}
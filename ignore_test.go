@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIgnorePatterns(t *testing.T) {
+	for _, tc := range []struct {
+		path, base, patterns string
+	}{
+		{"x.o", "", "[/x.o *.o]"},
+		{"sub/dir/x.o", "", "[/sub/dir/x.o *.o /sub/dir/]"},
+		{"sub/dir/x.o", "sub/", "[/dir/x.o *.o /dir/]"},
+		{"sub/x.o", "sub/", "[/x.o *.o]"},
+		{"build/", "", "[/build/]"},
+		{"sub/build/", "", "[/sub/build/ /sub/]"},
+		{".env", "", "[/.env]"},
+		{"a[1]*.txt", "", `[/a\[1]\*.txt *.txt]`},
+	} {
+		if out := fmt.Sprint(ignorePatterns(tc.path, tc.base)); out != tc.patterns {
+			t.Errorf("%s %q: got %s expected %s", tc.path, tc.base, out, tc.patterns)
+		}
+	}
+}
+
+func TestIgnoreTargets(t *testing.T) {
+	dir := testRepo(t)
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "a/b/c"), 0777)
+	testWrite(t, "a/.gitignore", "")
+
+	out := []string{}
+	for _, target := range ignoreTargets("a/b/c/x.o") {
+		out = append(out, target.File+"@"+target.Base)
+	}
+	if fmt.Sprint(out) != "[a/.gitignore@a/ .gitignore@ .git/info/exclude@]" {
+		t.Errorf("wrong targets %v", out)
+	}
+	if n := len(ignoreTargets("x.o")); n != 2 {
+		t.Errorf("wrong number of targets for a file in the root: %d", n)
+	}
+	if target := ignoreTargets("z/x.o")[0]; target.File != "z/.gitignore" {
+		t.Errorf("wrong nearest target %v", target)
+	}
+}
+
+func TestIgnore(t *testing.T) {
+	dir := testRepo(t)
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "sub/deep"), 0777)
+	testWrite(t, "x.o", "")
+	testWrite(t, "sub/y.o", "")
+	testWrite(t, "sub/deep/z.o", "")
+	testWrite(t, "sub/keep.txt", "")
+
+	hidden, err := hiddenByPattern("*.o", "sub/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(hidden) != "[sub/deep/z.o sub/y.o]" {
+		t.Errorf("wrong preview %v", hidden)
+	}
+
+	testWrite(t, "sub/.gitignore", "# no newline")
+	if err := appendIgnorePattern("sub/.gitignore", "*.o"); err != nil {
+		t.Fatal(err)
+	}
+	if err := appendIgnorePattern(".git/info/exclude", "/x.o"); err != nil {
+		t.Fatal(err)
+	}
+	if s := testRead(t, "sub/.gitignore"); s != "# no newline\n*.o\n" {
+		t.Errorf("wrong .gitignore %q", s)
+	}
+
+	rules := ignoreRules([]string{"x.o", "sub/deep/z.o", "sub/keep.txt"})
+	if len(rules) != 2 || !strings.HasPrefix(rules["x.o"], ".git/info/exclude:") || !strings.HasSuffix(rules["x.o"], ": /x.o") || rules["sub/deep/z.o"] != "sub/.gitignore:2: *.o" {
+		t.Errorf("wrong rules %v", rules)
+	}
+
+	status, err := gitStatusContext(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, line := range status.Lines {
+		if line.Category() == categoryIgnored {
+			n++
+		}
+	}
+	if n != 3 {
+		t.Errorf("wrong number of ignored files %v", status.Lines)
+	}
+}
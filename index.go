@@ -1,9 +1,11 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"image"
-	"io"
+	"image/color"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -14,6 +16,7 @@ import (
 	"github.com/aarzilli/nucular"
 	"github.com/aarzilli/nucular/label"
 	"github.com/aarzilli/nucular/rect"
+	"github.com/aarzilli/nucular/richtext"
 
 	"golang.org/x/mobile/event/key"
 )
@@ -21,7 +24,16 @@ import (
 type IndexManagerWindow struct {
 	selected            int
 	status              *GitStatus
+	statusErr           error
+	loading             bool // status is being loaded
+	statusGen           int
+	statusCancel        context.CancelFunc
+	diffLoading         bool
+	diffGen             int
+	diffCancel          context.CancelFunc
 	diff                Diff
+	diffCached          bool
+	stagedDiff          Diff // staged changes of a partially staged file
 	resetDiffViewScroll bool
 
 	splitv nucular.ScalableSplit
@@ -34,11 +46,34 @@ type IndexManagerWindow struct {
 
 	amend bool
 	ed    nucular.TextEditor
-
-	// when rebasedit is set the commit editor will show that file
-	rebasedit  string
-	rebasechan chan struct{}
-	rebaseTab  Tab
+	opts  commitOptions
+
+	history      []msgHistoryEntry
+	historyIdx   int    // position in history of the message, -1 if it is not from history
+	historyDraft string // message being written before moving through history
+
+	template       string // commit template, empty if not configured
+	templateLoaded bool   // the template was loaded in the editor
+	msgMode        string // kind of message loaded in the editor, see loadCommitMsg
+	lint           *lintConfig
+	lintErrs       []error
+
+	committing   bool
+	commitFailed string // error of the last commit, its output is in commitOutput
+	commitOutput nucular.TextEditor
+
+	treeView  bool
+	collapsed map[string]bool // collapsed directories of the tree view
+	filter    statusFilter
+	filterEd  nucular.TextEditor
+
+	ignoreRules map[string]string // rule ignoring each ignored file, see ignoreRules
+
+	// when editmsg is set the commit editor will show that file, the
+	// outcome of the edit must be sent to editSession
+	editmsg       string
+	editSession   *editSession
+	editReturnTab Tab
 }
 
 func (idxmw *IndexManagerWindow) Title() string {
@@ -64,33 +99,83 @@ func (idxmw *IndexManagerWindow) Update(w *nucular.Window) {
 	w.LayoutSpacePushScaled(leftbounds)
 	if sw := w.GroupBegin("index-files", nucular.WindowBorder); sw != nil {
 		cbw := min(int(25*style.Scaling), nucular.FontHeight(style.Font)+style.Option.Padding.Y) + style.Option.Padding.X*2
-		sw.Row(25).StaticScaled(cbw, 0)
+		if idxmw.loading {
+			sw.Row(25).Dynamic(1)
+			sw.Label("Loading...", "LC")
+		}
+		if idxmw.statusErr != nil {
+			sw.Row(25).Dynamic(1)
+			sw.Label(idxmw.statusErr.Error(), "LC")
+		}
 
-		for i, line := range idxmw.status.Lines {
-			checked := line.Index != " " && line.WorkDir == " "
+		if n := len(idxmw.status.Unmerged()); n > 0 {
+			sw.Row(25).Dynamic(1)
+			if sw.ButtonText(fmt.Sprintf("Resolve conflicts (%d)", n)) {
+				newConflictTab(w.Master())
+			}
+		}
+
+		idxmw.fileFilterBar(sw)
 
-			if sw.CheckboxText("", &checked) {
-				idxmw.addRemoveIndex(checked, i)
+		rows := idxmw.fileRows()
+		for _, row := range rows {
+			widths := make([]int, 0, 4)
+			if row.depth > 0 {
+				widths = append(widths, row.depth*cbw)
+			}
+			if idxmw.treeView {
+				widths = append(widths, cbw)
+			}
+			sw.Row(25).StaticScaled(append(widths, cbw, 0)...)
+			if row.depth > 0 {
+				sw.Spacing(1)
+			}
+
+			node := row.node
+			if node.Line < 0 {
+				collapsed := idxmw.collapsed[node.Path]
+				symbol := label.SymbolTriangleDown
+				if collapsed {
+					symbol = label.SymbolTriangleRight
+				}
+				if sw.Button(label.S(symbol), false) {
+					idxmw.collapsed[node.Path] = !collapsed
+				}
+				checked := node.Count > 0 && node.Staged == node.Count
+				if checkboxTristate(sw, &checked, !checked && node.Staged+node.Partial > 0) {
+					idxmw.addRemoveDir(checked, node)
+				}
+				selected := false
+				if sw.SelectableLabel(fmt.Sprintf("%s/ (%d)", node.Name, node.Count), "LC", &selected) {
+					idxmw.collapsed[node.Path] = !collapsed
+				}
+				continue
+			}
+
+			if idxmw.treeView {
+				sw.Spacing(1)
+			}
+
+			i := node.Line
+			line := idxmw.status.Lines[i]
+			name := node.Name
+			if line.Index == "!" {
+				sw.Spacing(1)
+				if rule := idxmw.ignoreRules[line.Path]; rule != "" {
+					name = fmt.Sprintf("%s (%s)", name, rule)
+				}
+			} else {
+				checked := line.Index != " " && line.WorkDir == " "
+				if checkboxTristate(sw, &checked, line.PartiallyStaged()) {
+					idxmw.addRemoveIndex(checked, i)
+				}
 			}
 
 			selected := idxmw.selected == i
-			sw.SelectableLabel(idxmw.status.Lines[i].Path, "LC", &selected)
+			sw.SelectableLabel(name, "LC", &selected)
 			if w := sw.ContextualOpen(0, image.Point{200, 500}, sw.LastWidgetBounds, nil); w != nil {
-				w.Row(20).Dynamic(1)
 				selected = true
-				if os.Getenv("EDITOR") != "" {
-					if w.MenuItem(label.TA("Edit", "LC")) {
-						cmd := exec.Command(os.Getenv("EDITOR"), idxmw.status.Lines[i].Path)
-						cmd.Dir = Repodir
-						cmd.Start()
-						go cmd.Wait()
-					}
-				}
-				if idxmw.status.Lines[i].Index == "?" && idxmw.status.Lines[i].WorkDir == "?" {
-					if w.MenuItem(label.TA("Ignore", "LC")) {
-						idxmw.ignoreIndex(i)
-					}
-				}
+				idxmw.fileContextMenu(w, i)
 			}
 
 			if selected && idxmw.selected != i && i < len(idxmw.status.Lines) {
@@ -98,6 +183,10 @@ func (idxmw *IndexManagerWindow) Update(w *nucular.Window) {
 				idxmw.loadDiff()
 			}
 		}
+		if len(rows) == 0 && len(idxmw.status.Lines) > 0 {
+			sw.Row(25).Dynamic(1)
+			sw.Label("No changed file matches the filter", "LC")
+		}
 		sw.GroupEnd()
 	}
 
@@ -108,15 +197,39 @@ func (idxmw *IndexManagerWindow) Update(w *nucular.Window) {
 			diffgroup.Scrollbar.X = 0
 			diffgroup.Scrollbar.Y = 0
 		}
-		if idxmw.selected >= 0 {
-			showDiff(diffgroup, idxmw.diff)
+		if idxmw.diffLoading {
+			diffgroup.Row(25).Dynamic(1)
+			diffgroup.Label("Loading...", "LC")
+		} else if idxmw.selected >= 0 {
+			staged := []hunkAction{{"Unstage", idxmw.stageHunk(true)}, {"Discard", idxmw.discardHunk(true)}}
+			unstaged := []hunkAction{{"Stage", idxmw.stageHunk(false)}, {"Discard", idxmw.discardHunk(false)}}
+			switch {
+			case idxmw.stagedDiff != nil:
+				if diffgroup.TreePush(nucular.TreeNode, "Staged", true) {
+					showDiffHunks(diffgroup, idxmw.stagedDiff, staged[:1]...)
+					diffgroup.TreePop()
+				}
+				if diffgroup.TreePush(nucular.TreeNode, "Unstaged", true) {
+					showDiffHunks(diffgroup, idxmw.diff, unstaged...)
+					diffgroup.TreePop()
+				}
+			case idxmw.diffCached:
+				showDiffHunks(diffgroup, idxmw.diff, staged...)
+			default:
+				showDiffHunks(diffgroup, idxmw.diff, unstaged...)
+			}
+			scrollingKeys(diffgroup, nucular.FontHeight(style.Font))
 		}
 		diffgroup.GroupEnd()
 	}
 
 	w.LayoutSpacePushScaled(commitbounds)
 	if sw := w.GroupBegin("index-right-column", nucular.WindowNoScrollbar|nucular.WindowBorder); sw != nil {
-		sw.Row(25).Static(100, 100, 100, 50, 0, 100)
+		if idxmw.editmsg != "" {
+			sw.Row(25).Static(100, 100, 100, 50, 80, 0, 100, 100)
+		} else {
+			sw.Row(25).Static(100, 100, 100, 50, 80, 90, 0, 100)
+		}
 		oldamend := idxmw.amend
 		if sw.OptionText("New commit", idxmw.amend == false) {
 			idxmw.amend = false
@@ -125,9 +238,11 @@ func (idxmw *IndexManagerWindow) Update(w *nucular.Window) {
 			idxmw.amend = true
 		}
 		if idxmw.amend != oldamend {
+			idxmw.saveDraft()
 			if !idxmw.amend {
 				idxmw.ed.Buffer = idxmw.ed.Buffer[:0]
 				idxmw.ed.Cursor = 0
+				idxmw.templateLoaded = false
 			}
 			idxmw.loadCommitMsg()
 		}
@@ -136,109 +251,432 @@ func (idxmw *IndexManagerWindow) Update(w *nucular.Window) {
 		if sw.ButtonText("fmt") {
 			idxmw.formatmsg()
 		}
+		sw.Menu(label.TA("History", "CC"), 400, idxmw.historyMenu)
+		if idxmw.editmsg == "" {
+			lbl := "Options"
+			if idxmw.opts.active(idxmw.amend) {
+				lbl = "Options*"
+			}
+			sw.CheckboxText(lbl, &idxmw.opts.show)
+		}
 		sw.Spacing(1)
-		if sw.ButtonText("Commit") {
-			if idxmw.rebasedit != "" {
-				fh, _ := os.Create(idxmw.rebasedit)
-				io.WriteString(fh, string(idxmw.ed.Buffer))
-				fh.Close()
-				close(idxmw.rebasechan)
-				idxmw.rebasechan = nil
-				idxmw.rebasedit = ""
-				if i := tabIndex(idxmw.rebaseTab); i >= 0 {
-					currentTab = i
-				}
-			} else {
-				var cmd *exec.Cmd
-				if idxmw.amend {
-					cmd = exec.Command("git", "commit", "--amend", "-F", "-")
-				} else {
-					cmd = exec.Command("git", "commit", "-F", "-")
-				}
-				cmd.Dir = Repodir
-				gitin, err := cmd.StdinPipe()
-				must(err)
-				go func() {
-					io.WriteString(gitin, string(idxmw.ed.Buffer))
-					gitin.Close()
-				}()
-				bs, err := cmd.CombinedOutput()
+		if idxmw.editmsg != "" {
+			if sw.ButtonText("Cancel") {
+				idxmw.endEditMessage(editorResponse{Cancelled: true})
+				go lw.reload()
+				idxmw.reload()
+			}
+		}
+		if idxmw.committing {
+			sw.Label("Committing...", "RC")
+		} else if sw.ButtonText("Commit") {
+			if idxmw.lintBlocks() {
+				idxmw.commitFailed = "Commit blocked by the rules for commit messages"
+				idxmw.commitOutput.Buffer = []rune("See the messages in red under the editor, blocking rules are set with git config fkgit.lintBlocking\n")
+			} else if idxmw.editmsg != "" {
+				err := ioutil.WriteFile(idxmw.editmsg, []byte(string(idxmw.ed.Buffer)), 0666)
 				if err != nil {
-					newMessagePopup(w.Master(), "Error", fmt.Sprintf("error: %v\n%s\n", err, bs))
+					idxmw.endEditMessage(editorResponse{Error: fmt.Sprintf("could not write %s: %v", idxmw.editmsg, err)})
 				} else {
-					idxmw.amend = false
-					idxmw.ed.Buffer = []rune{}
-					idxmw.ed.Cursor = 0
+					idxmw.endEditMessage(editorResponse{Ok: true})
 				}
+				go lw.reload()
+				idxmw.reload()
+			} else {
+				idxmw.commit()
 			}
-			go lw.reload()
-			idxmw.reload()
+		}
 
+		if idxmw.editmsg == "" && idxmw.opts.show {
+			idxmw.opts.Update(sw, idxmw.amend)
 		}
 
-		sw.Row(0).Dynamic(1)
+		if idxmw.commitFailed != "" {
+			sw.Row(25).Static(0, 100)
+			sw.Label(idxmw.commitFailed, "LC")
+			if sw.ButtonText("Close") {
+				idxmw.commitFailed = ""
+			}
+			sw.Row(150).Dynamic(1)
+			idxmw.commitOutput.Edit(sw)
+		}
+
+		if idxmw.ed.Active {
+			for _, e := range sw.Input().Keyboard.Keys {
+				switch {
+				case (e.Modifiers == key.ModControl) && (e.Code == key.CodeUpArrow):
+					idxmw.historyMove(+1)
+				case (e.Modifiers == key.ModControl) && (e.Code == key.CodeDownArrow):
+					idxmw.historyMove(-1)
+				}
+			}
+		}
+
+		warnings := idxmw.lintWarnings()
+		rowh := int(20 * style.Scaling)
+		sw.RowScaled(sw.LayoutAvailableHeight() - (len(warnings)+len(idxmw.lintErrs))*(rowh+style.GroupWindow.Spacing.Y)).Dynamic(1)
 		idxmw.ed.Edit(sw)
+		sw.RowScaled(rowh).Dynamic(1)
+		for _, warning := range warnings {
+			if idxmw.lint.isBlocking(warning.rule) {
+				sw.LabelColored(warning.msg, "LC", lintErrorColor)
+			} else {
+				sw.LabelColored(warning.msg, "LC", lintWarningColor)
+			}
+		}
+		for _, err := range idxmw.lintErrs {
+			sw.LabelColored(err.Error(), "LC", lintErrorColor)
+		}
 		sw.GroupEnd()
 	}
 
 	in := w.Input()
-	if !idxmw.ed.Active && !in.Mouse.HoveringRect(diffbounds) {
+	if !idxmw.ed.Active && !idxmw.filterEd.Active && !in.Mouse.HoveringRect(diffbounds) {
 		for _, e := range in.Keyboard.Keys {
 			switch {
 			case (e.Modifiers == 0) && (e.Code == key.CodeUpArrow):
-				idxmw.selected--
-				if idxmw.selected < 0 {
-					idxmw.selected = -1
-				}
-				idxmw.loadDiff()
+				idxmw.moveSelection(-1)
 			case (e.Modifiers == 0) && (e.Code == key.CodeDownArrow):
-				idxmw.selected++
-				if idxmw.selected >= len(idxmw.status.Lines) {
-					if len(idxmw.status.Lines) == 0 {
-						idxmw.selected = -1
-					} else {
-						idxmw.selected = 0
-					}
-				}
-				idxmw.loadDiff()
+				idxmw.moveSelection(+1)
 			}
 		}
 		if in.Keyboard.Text == " " {
-			if idxmw.selected >= 0 {
+			if idxmw.selected >= 0 && idxmw.status.Lines[idxmw.selected].Index != "!" {
 				idxmw.addRemoveIndex(true, idxmw.selected)
 			}
-			idxmw.selected++
-			if idxmw.selected >= len(idxmw.status.Lines) {
-				idxmw.selected = 0
+			idxmw.moveSelection(+1)
+		}
+	}
+}
+
+// fileFilterBar shows the controls for the view and filter of the list of
+// changed files.
+func (idxmw *IndexManagerWindow) fileFilterBar(sw *nucular.Window) {
+	sw.Row(25).Static(0, 60, 60)
+	idxmw.filterEd.Edit(sw)
+	sw.CheckboxText("Tree", &idxmw.treeView)
+	lbl := "Show"
+	for c := statusCategory(0); c < numStatusCategories; c++ {
+		if idxmw.filter.hide[c] != (c == categoryIgnored) {
+			lbl = "Show*"
+		}
+	}
+	sw.Menu(label.TA(lbl, "CC"), 120, func(w *nucular.Window) {
+		idxmw.mu.Lock()
+		defer idxmw.mu.Unlock()
+		w.Row(20).Dynamic(1)
+		for c := statusCategory(0); c < numStatusCategories; c++ {
+			show := !idxmw.filter.hide[c]
+			if w.CheckboxText(statusCategoryNames[c], &show) {
+				idxmw.filter.hide[c] = !show
+				if c == categoryIgnored {
+					// ignored files are only loaded when they are shown
+					idxmw.reload()
+				}
 			}
-			idxmw.loadDiff()
+		}
+	})
+	idxmw.filter.name = string(idxmw.filterEd.Buffer)
+}
+
+// fileRows returns the rows of the list of changed files, as a tree or as a
+// flat list.
+func (idxmw *IndexManagerWindow) fileRows() []fileTreeRow {
+	if !idxmw.treeView {
+		return flatFileRows(idxmw.status.Lines, &idxmw.filter)
+	}
+	return buildFileTree(idxmw.status.Lines, &idxmw.filter).rows(idxmw.collapsed, 0, nil)
+}
+
+func (idxmw *IndexManagerWindow) fileContextMenu(w *nucular.Window, i int) {
+	w.Row(20).Dynamic(1)
+	if os.Getenv("EDITOR") != "" {
+		if w.MenuItem(label.TA("Edit", "LC")) {
+			cmd := exec.Command(os.Getenv("EDITOR"), idxmw.status.Lines[i].Path)
+			cmd.Dir = Repodir
+			cmd.Start()
+			go cmd.Wait()
+		}
+	}
+	if idxmw.status.Lines[i].Unmerged() {
+		if w.MenuItem(label.TA("Resolve conflicts", "LC")) {
+			newConflictTab(w.Master())
+		}
+	}
+	if idxmw.status.Lines[i].canDiscard() {
+		if w.MenuItem(label.TA("Discard changes", "LC")) {
+			idxmw.discardFile(i)
+		}
+	}
+	if w.MenuItem(label.TA("Recently discarded...", "LC")) {
+		newDiscardedTab(idxmw.mw)
+	}
+	if w.MenuItem(label.TA("Stash...", "LC")) {
+		newStashPopup(idxmw.mw, idxmw.status.Lines, idxmw.status.Lines[i].Path)
+	}
+	if w.MenuItem(label.TA("Clean...", "LC")) {
+		newCleanPopup(idxmw.mw)
+	}
+	if idxmw.status.Lines[i].Index == "?" && idxmw.status.Lines[i].WorkDir == "?" {
+		if w.MenuItem(label.TA("Ignore...", "LC")) {
+			newIgnorePopup(idxmw.mw, idxmw.status.Lines[i].Path)
 		}
 	}
 }
 
+// addRemoveDir stages or unstages all the files under a directory of the
+// tree, ignored files and files with merge conflicts are left alone.
+func (idxmw *IndexManagerWindow) addRemoveDir(add bool, node *fileTreeNode) {
+	paths := []string{}
+	for _, i := range node.lines(nil) {
+		if line := &idxmw.status.Lines[i]; !line.Unmerged() && line.Index != "!" {
+			paths = append(paths, line.Path)
+		}
+	}
+	if len(paths) == 0 {
+		return
+	}
+	args := []string{"reset", "-q", "--"}
+	if add {
+		args = []string{"add", "--"}
+	}
+	idxmw.run(editorCommand("git", append(args, paths...)...))
+}
+
 func (idxmw *IndexManagerWindow) addRemoveIndex(add bool, i int) {
 	if add {
-		execCommand("git", "add", idxmw.status.Lines[i].Path)
+		idxmw.run(editorCommand("git", "add", "--", idxmw.status.Lines[i].Path))
 	} else {
-		execCommand("git", "reset", "-q", "--", idxmw.status.Lines[i].Path)
+		idxmw.run(editorCommand("git", "reset", "-q", "--", idxmw.status.Lines[i].Path))
 	}
-	idxmw.reload()
 }
 
-func (idxmw *IndexManagerWindow) ignoreIndex(i int) {
-	fh, err := os.OpenFile(filepath.Join(Repodir, ".git/info/exclude"), os.O_APPEND|os.O_WRONLY, 0)
-	if err != nil {
-		return
+// run runs cmd as a job in the background and reloads the status.
+func (idxmw *IndexManagerWindow) run(cmd *exec.Cmd) {
+	go func() {
+		out, err := runJob(cmd)
+		if err != nil && err != errJobCancelled {
+			newMessagePopup(idxmw.mw, "Error", fmt.Sprintf("Error: %v\n%s\n", err, out))
+		}
+		idxmw.reload()
+	}()
+}
+
+// commit runs git commit in the background, if it fails (for example
+// because of a hook) the output of git is shown and the message is kept.
+func (idxmw *IndexManagerWindow) commit() {
+	idxmw.committing = true
+	idxmw.commitFailed = ""
+	args := idxmw.opts.args(idxmw.amend)
+	if idxmw.templateLoaded {
+		// templates use comments to describe the message
+		args = append([]string{args[0], "--cleanup=strip"}, args[1:]...)
 	}
-	defer fh.Close()
-	fmt.Fprintf(fh, "\n%s\n", idxmw.status.Lines[i].Path)
-	idxmw.reload()
+	msg := string(idxmw.ed.Buffer)
+	go func() {
+		cmd := editorCommand("git", args...)
+		cmd.Stdin = strings.NewReader(msg)
+		out, err := runJob(cmd)
+
+		idxmw.mu.Lock()
+		defer idxmw.mu.Unlock()
+		idxmw.committing = false
+		idxmw.addHistory(msg, err == nil)
+		if err != nil {
+			idxmw.commitFailed = fmt.Sprintf("Commit failed: %v", err)
+			idxmw.commitOutput.Buffer = []rune(out)
+			idxmw.commitOutput.Cursor = 0
+			// hooks can change the work tree, the message is kept as is
+			idxmw.reloadStatus(false)
+		} else {
+			idxmw.amend = false
+			idxmw.opts.resetAuthor = false
+			if string(idxmw.ed.Buffer) == msg {
+				idxmw.ed.Buffer = []rune{}
+				idxmw.ed.Cursor = 0
+				idxmw.templateLoaded = false
+			}
+			go lw.reload()
+			idxmw.reload()
+		}
+		idxmw.mw.Changed()
+	}()
+}
+
+var lintWarningColor = color.RGBA{0xff, 0xd0, 0x60, 0xff}
+var lintErrorColor = color.RGBA{0xff, 0x60, 0x60, 0xff}
+
+func (idxmw *IndexManagerWindow) lintWarnings() []lintWarning {
+	if idxmw.lint == nil {
+		return nil
+	}
+	cfg := *idxmw.lint
+	cfg.stripComments = idxmw.templateLoaded
+	return lintCommitMsg(string(idxmw.ed.Buffer), &cfg)
+}
+
+// lintBlocks returns true if the commit message violates a blocking rule.
+func (idxmw *IndexManagerWindow) lintBlocks() bool {
+	for _, warning := range idxmw.lintWarnings() {
+		if idxmw.lint.isBlocking(warning.rule) {
+			return true
+		}
+	}
+	return false
+}
+
+// moveSelection selects the file delta rows after the currently selected
+// one, skipping directories and files that are not visible. Moving up from
+// the first file clears the selection, moving down from the last file wraps
+// around.
+func (idxmw *IndexManagerWindow) moveSelection(delta int) {
+	visible := []int{}
+	for _, row := range idxmw.fileRows() {
+		if row.node.Line >= 0 {
+			visible = append(visible, row.node.Line)
+		}
+	}
+	cur := -1
+	for j, i := range visible {
+		if i == idxmw.selected {
+			cur = j
+		}
+	}
+	cur += delta
+	switch {
+	case len(visible) == 0 || (cur < 0 && delta < 0):
+		idxmw.selected = -1
+	case cur < 0 || cur >= len(visible):
+		idxmw.selected = visible[0]
+	default:
+		idxmw.selected = visible[cur]
+	}
+	idxmw.loadDiff()
+}
+
+// stageHunk returns a function that stages the selected lines of a hunk,
+// or unstages them if cached is set.
+func (idxmw *IndexManagerWindow) stageHunk(cached bool) func(fd *FileDiff, hunk int, sel []bool) {
+	return func(fd *FileDiff, hunk int, sel []bool) {
+		patch, ok := fd.hunkPatch(hunk, sel, cached)
+		if !ok {
+			return
+		}
+		args := []string{"apply", "--cached"}
+		if cached {
+			args = append(args, "-R")
+		}
+		cmd := editorCommand("git", args...)
+		cmd.Stdin = strings.NewReader(patch)
+		idxmw.run(cmd)
+	}
+}
+
+// discardHunk returns a function that removes the selected lines of a hunk
+// from the work tree, and also from the index if cached is set.
+func (idxmw *IndexManagerWindow) discardHunk(cached bool) func(fd *FileDiff, hunk int, sel []bool) {
+	return func(fd *FileDiff, hunk int, sel []bool) {
+		patch, ok := fd.hunkPatch(hunk, sel, true)
+		if !ok {
+			return
+		}
+		filename := fd.Filename
+		args := []string{"apply", "-R"}
+		if cached {
+			args = append(args, "--index")
+		}
+		go func() {
+			if err := snapshotWorktree("Discard hunk of "+filename, filename); err != nil {
+				newMessagePopup(idxmw.mw, "Error", fmt.Sprintf("Could not save changes, nothing was discarded: %v\n", err))
+				return
+			}
+			cmd := editorCommand("git", args...)
+			cmd.Stdin = strings.NewReader(patch)
+			if out, err := runJob(cmd); err != nil && err != errJobCancelled {
+				newMessagePopup(idxmw.mw, "Error", fmt.Sprintf("Error: %v\n%s\n", err, out))
+			}
+			idxmw.reload()
+		}()
+	}
+}
+
+// discardFile throws away all changes to a file, untracked files are
+// deleted.
+func (idxmw *IndexManagerWindow) discardFile(i int) {
+	line := idxmw.status.Lines[i]
+	go func() {
+		if err := snapshotWorktree("Discard changes to "+line.Path, line.Path); err != nil {
+			newMessagePopup(idxmw.mw, "Error", fmt.Sprintf("Could not save changes, nothing was discarded: %v\n", err))
+			return
+		}
+		var out string
+		var err error
+		switch {
+		case line.Index == "?" && line.WorkDir == "?":
+			err = os.RemoveAll(filepath.Join(Repodir, line.Path))
+		case line.Index == "A":
+			out, err = runJob(editorCommand("git", "rm", "-q", "-f", "--", line.Path))
+		case line.WorkDir == " ":
+			out, err = runJob(editorCommand("git", "checkout", "-q", "HEAD", "--", line.Path))
+		default:
+			out, err = runJob(editorCommand("git", "checkout", "-q", "--", line.Path))
+		}
+		if err != nil && err != errJobCancelled {
+			newMessagePopup(idxmw.mw, "Error", fmt.Sprintf("Error: %v\n%s\n", err, out))
+		}
+		idxmw.reload()
+	}()
 }
 
+// reload loads the status of the repository, the diff of the selected
+// file and the commit message in the background, a reload started while
+// another is in progress cancels it.
 func (idxmw *IndexManagerWindow) reload() {
+	idxmw.reloadStatus(true)
+}
+
+// reloadStatus is like reload but only loads the commit message if loadMsg
+// is set.
+func (idxmw *IndexManagerWindow) reloadStatus(loadMsg bool) {
 	go func() {
+		idxmw.mu.Lock()
+		idxmw.statusGen++
+		gen := idxmw.statusGen
+		if idxmw.statusCancel != nil {
+			idxmw.statusCancel()
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		idxmw.statusCancel = cancel
+		idxmw.loading = true
+		if loadMsg {
+			idxmw.loadCommitMsg()
+		}
+		showIgnored := !idxmw.filter.hide[categoryIgnored]
+		idxmw.mu.Unlock()
+
+		status, err := gitStatusContext(ctx, showIgnored)
+		lint, lintErrs := loadLintConfig()
+		var rules map[string]string
+		if err == nil && showIgnored {
+			ignored := []string{}
+			for _, line := range status.Lines {
+				if line.Index == "!" {
+					ignored = append(ignored, line.Path)
+				}
+			}
+			rules = ignoreRules(ignored)
+		}
+
 		idxmw.mu.Lock()
 		defer idxmw.mu.Unlock()
+		if gen != idxmw.statusGen {
+			return
+		}
+		idxmw.lint, idxmw.lintErrs = lint, lintErrs
+		idxmw.ignoreRules = rules
+		cancel()
+		idxmw.statusCancel = nil
+		idxmw.loading = false
 
 		oldselected := ""
 		if idxmw.status != nil && idxmw.selected >= 0 {
@@ -246,9 +684,10 @@ func (idxmw *IndexManagerWindow) reload() {
 		}
 		idxmw.selected = -1
 
-		idxmw.loadCommitMsg()
-
-		idxmw.status = gitStatus()
+		if err != nil {
+			status = &GitStatus{}
+		}
+		idxmw.status, idxmw.statusErr = status, err
 
 		for i, line := range idxmw.status.Lines {
 			if line.Path == oldselected {
@@ -259,61 +698,204 @@ func (idxmw *IndexManagerWindow) reload() {
 		}
 
 		idxmw.loadDiff()
+		idxmw.mw.Changed()
 	}()
 }
 
+type fileDiffs struct {
+	diff   Diff
+	staged Diff // staged changes of a partially staged file
+	cached bool // diff is the diff between HEAD and the index
+}
+
+// loadDiff loads the diff for the selected file in the background, must be
+// called with idxmw.mu held.
 func (idxmw *IndexManagerWindow) loadDiff() {
+	idxmw.diffGen++
+	if idxmw.diffCancel != nil {
+		idxmw.diffCancel()
+		idxmw.diffCancel = nil
+	}
+	idxmw.diffLoading = false
 	if idxmw.selected < 0 {
 		return
 	}
 
 	line := idxmw.status.Lines[idxmw.selected]
+	gen := idxmw.diffGen
+	ctx, cancel := context.WithCancel(context.Background())
+	idxmw.diffCancel = cancel
+	idxmw.diffLoading = true
 
-	var bs string
-	var err error
+	go func() {
+		d := loadFileDiffs(ctx, line)
 
-	if line.Index != " " && line.WorkDir == " " {
-		bs, err = execCommand("git", "diff", "--color=never", "--cached", "--", line.Path)
-	} else {
-		bs, err = execCommand("git", "diff", "--color=never", "--", line.Path)
+		idxmw.mu.Lock()
+		defer idxmw.mu.Unlock()
+		if gen != idxmw.diffGen {
+			return
+		}
+		cancel()
+		idxmw.diffCancel = nil
+		idxmw.diffLoading = false
+		idxmw.diff, idxmw.stagedDiff, idxmw.diffCached = d.diff, d.staged, d.cached
+		idxmw.resetDiffViewScroll = true
+		idxmw.mw.Changed()
+	}()
+}
+
+func loadFileDiffs(ctx context.Context, line StatusLine) (r fileDiffs) {
+	load := func(bs string, err error) Diff {
+		if err != nil {
+			return errorDiff(line.Path, bs, err)
+		}
+		diff := parseDiff([]byte(bs))
+		describeBinary(diff)
+		return diff
+	}
+
+	r.cached = line.Index != " " && line.WorkDir == " "
+	if line.PartiallyStaged() {
+		r.staged = load(execCommandContext(ctx, "git", "diff", "--color=never", "--cached", "--", line.Path))
 	}
-	must(err)
-	idxmw.resetDiffViewScroll = true
-	idxmw.diff = parseDiff([]byte(bs))
+	switch {
+	case line.Index == "?" && line.WorkDir == "?":
+		r.diff = load(untrackedDiff(ctx, line.Path))
+	case line.Index == "!":
+		if strings.HasSuffix(line.Path, "/") {
+			r.diff = Diff{{Filename: line.Path, Filedescr: line.Path, rtxt: richtext.New(richtext.Selectable | richtext.Clipboard)}}
+		} else {
+			r.diff = load(untrackedDiff(ctx, line.Path))
+		}
+		if len(r.diff) > 0 {
+			if rule := ignoreRules([]string{line.Path})[line.Path]; rule != "" {
+				r.diff[0].Info = append([]string{"Ignored by " + rule}, r.diff[0].Info...)
+			}
+		}
+	case r.cached:
+		r.diff = load(execCommandContext(ctx, "git", "diff", "--color=never", "--cached", "--", line.Path))
+	default:
+		r.diff = load(execCommandContext(ctx, "git", "diff", "--color=never", "--", line.Path))
+	}
+	return r
 }
 
-func (idxmw *IndexManagerWindow) loadCommitMsg() {
-	loadfile := func(filename string) error {
-		bs, err := ioutil.ReadFile(filename)
-		if err == nil {
-			idxmw.ed.Cursor = 0
-			idxmw.ed.Buffer = []rune(string(bs))
+// untrackedDiff returns a diff adding the untracked files in path, which
+// can be a directory.
+func untrackedDiff(ctx context.Context, path string) (string, error) {
+	files := []string{path}
+	if strings.HasSuffix(path, "/") {
+		out, err := execCommandContext(ctx, "git", "ls-files", "-z", "--others", "--exclude-standard", "--", path)
+		if err != nil {
+			return out, err
+		}
+		files = strings.Split(strings.TrimRight(out, "\x00"), "\x00")
+	}
+	var buf bytes.Buffer
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		out, err := execCommandContext(ctx, "git", "diff", "--color=never", "--no-index", "--", "/dev/null", file)
+		if exiterr, ok := err.(*exec.ExitError); ok && exiterr.ExitCode() == 1 {
+			// exit status 1 means that the files differ
+			err = nil
 		}
-		return err
+		if err != nil {
+			return out, err
+		}
+		buf.WriteString(out)
+	}
+	return buf.String(), nil
+}
+
+// loadCommitMsg loads the commit message in the background, must be called
+// with idxmw.mu held.
+func (idxmw *IndexManagerWindow) loadCommitMsg() {
+	go idxmw.updateCommitMsg(idxmw.editmsg, idxmw.amend)
+}
+
+// updateCommitMsg loads the message for editmsg and amend in the editor.
+// The message being written is only replaced if it is empty or if the kind
+// of message changed (a file from an editor request, the message of the
+// amended commit or the message of a merge).
+func (idxmw *IndexManagerWindow) updateCommitMsg(editmsg string, amend bool) {
+	msg, ok := readCommitMsg(editmsg, amend)
+	var mode string
+	switch {
+	case editmsg != "":
+		mode = "edit " + editmsg
+	case amend:
+		mode = "amend"
+	case ok:
+		mode = "merge"
+	}
+	template := ""
+	if !ok && editmsg == "" && !amend {
+		template, ok = commitTemplate()
+		msg = template
+	}
+	idxmw.mu.Lock()
+	defer idxmw.mu.Unlock()
+	if idxmw.editmsg != editmsg || idxmw.amend != amend {
+		return
 	}
+	idxmw.template = template
+	oldmode := idxmw.msgMode
+	idxmw.msgMode = mode
+	if !ok || (len(idxmw.ed.Buffer) != 0 && (template != "" || mode == oldmode)) {
+		// the template is only used for empty messages
+		return
+	}
+	idxmw.ed.Cursor = 0
+	idxmw.ed.Buffer = []rune(msg)
+	idxmw.templateLoaded = template != ""
+	idxmw.mw.Changed()
+}
 
+func readCommitMsg(editmsg string, amend bool) (string, bool) {
 	switch {
-	case idxmw.rebasedit != "":
-		loadfile(idxmw.rebasedit)
-	case idxmw.amend:
+	case editmsg != "":
+		bs, err := ioutil.ReadFile(editmsg)
+		return string(bs), err == nil
+	case amend:
 		out, err := execCommand("git", "cat-file", "commit", "HEAD")
-		must(err)
+		if err != nil {
+			return "", false
+		}
 		msgv := strings.Split(out, "\n")
 		for i := range msgv {
 			if msgv[i] == "" {
-				idxmw.ed.Cursor = 0
-				idxmw.ed.Buffer = append(idxmw.ed.Buffer[:0], []rune(strings.Join(msgv[i+1:], "\n"))...)
-				return
+				return strings.Join(msgv[i+1:], "\n"), true
 			}
 		}
 	default:
 		for _, name := range []string{"MERGE_MSG", "SQUASH_MSG"} {
-			if err := loadfile(filepath.Join(filepath.Join(Repodir, ".git"), name)); err == nil {
-				return
+			if bs, err := ioutil.ReadFile(filepath.Join(filepath.Join(Repodir, ".git"), name)); err == nil {
+				return string(bs), true
 			}
-
 		}
 	}
+	return "", false
+}
+
+// endEditMessage sends resp to the editor request being handled by the
+// commit tab, must be called with the UI lock held.
+func (idxmw *IndexManagerWindow) endEditMessage(resp editorResponse) {
+	idxmw.editSession.reply(resp)
+	if i := tabIndex(idxmw.editReturnTab); i >= 0 {
+		currentTab = i
+	}
+	idxmw.clearEditMessage()
+}
+
+func (idxmw *IndexManagerWindow) clearEditMessage() {
+	idxmw.editmsg = ""
+	idxmw.editSession = nil
+	idxmw.editReturnTab = nil
+	idxmw.ed.Buffer = idxmw.ed.Buffer[:0]
+	idxmw.ed.Cursor = 0
+	idxmw.templateLoaded = false
 }
 
 func (idxmw *IndexManagerWindow) formatmsg() {
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUpdateCommitMsg(t *testing.T) {
+	dir := testRepo(t)
+	defer os.RemoveAll(dir)
+
+	w := &IndexManagerWindow{mw: &testMasterWindow{}}
+	msg := func() string {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return string(w.ed.Buffer)
+	}
+
+	w.amend = true
+	w.updateCommitMsg("", true)
+	if m := msg(); m != "first\n" {
+		t.Fatalf("wrong amended message %q", m)
+	}
+
+	// reloading must not replace the message being written
+	w.ed.Buffer = []rune("edited\n")
+	w.updateCommitMsg("", true)
+	if m := msg(); m != "edited\n" {
+		t.Errorf("message replaced by a reload %q", m)
+	}
+
+	// a merge changes the message
+	w.amend = false
+	testWrite(t, ".git/MERGE_MSG", "Merge branch 'other'\n")
+	w.updateCommitMsg("", false)
+	if m := msg(); m != "Merge branch 'other'\n" {
+		t.Errorf("wrong merge message %q", m)
+	}
+}
+
+func TestCommitFailedAmend(t *testing.T) {
+	dir := testRepo(t)
+	defer os.RemoveAll(dir)
+	testGit(t, "config", "user.name", "test")
+	testGit(t, "config", "user.email", "test@example.com")
+	testWrite(t, ".git/hooks/pre-commit", "#!/bin/sh\necho rejected\nexit 1\n")
+	must(os.Chmod(filepath.Join(dir, ".git/hooks/pre-commit"), 0755))
+
+	mw := &testMasterWindow{}
+	w := &IndexManagerWindow{mw: mw}
+	w.amend = true
+	w.updateCommitMsg("", true)
+
+	w.mu.Lock()
+	w.ed.Buffer = []rune("edited\n")
+	w.commit()
+	w.mu.Unlock()
+	testWaitFor(t, mw, "the commit", func() bool {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return !w.committing && w.status != nil && !w.loading
+	})
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.commitFailed == "" || !strings.Contains(string(w.commitOutput.Buffer), "rejected") {
+		t.Errorf("commit did not fail: %q %q", w.commitFailed, string(w.commitOutput.Buffer))
+	}
+	if m := string(w.ed.Buffer); m != "edited\n" || !w.amend {
+		t.Errorf("message lost after a failed amend %q (amend %v)", m, w.amend)
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aarzilli/nucular"
+)
+
+// The job manager runs the git commands that change the repository one at
+// a time, in the order they were requested, and keeps a log of every
+// command it ran during the session.
+
+var errJobCancelled = errors.New("cancelled")
+
+type jobState int
+
+const (
+	jobQueued jobState = iota
+	jobRunning
+	jobDone
+)
+
+type job struct {
+	Cmdline    string
+	Queued     time.Time
+	Start, End time.Time
+	State      jobState
+	Err        error
+
+	output    []byte
+	cmd       *exec.Cmd
+	cancelled bool
+	cancelch  chan struct{} // closed to cancel a queued job
+}
+
+// Status describes the state and exit status of the job.
+func (j *job) Status() string {
+	switch {
+	case j.State == jobQueued:
+		return "queued"
+	case j.State == jobRunning:
+		return "running"
+	case j.cancelled:
+		return "cancelled"
+	case j.Err == nil:
+		return "ok"
+	}
+	if exiterr, ok := j.Err.(*exec.ExitError); ok && exiterr.ExitCode() >= 0 {
+		return fmt.Sprintf("exit %d", exiterr.ExitCode())
+	}
+	return "error"
+}
+
+func (j *job) Duration() time.Duration {
+	switch j.State {
+	case jobQueued:
+		return 0
+	case jobRunning:
+		return time.Since(j.Start)
+	}
+	return j.End.Sub(j.Start)
+}
+
+type jobManager struct {
+	mu   sync.Mutex
+	jobs []*job
+	sem  chan struct{} // held by the running job
+	mw   nucular.MasterWindow
+}
+
+var jobs = jobManager{sem: make(chan struct{}, 1)}
+
+type jobWriter struct {
+	j        *job
+	onOutput func([]byte)
+}
+
+func (w *jobWriter) Write(bs []byte) (int, error) {
+	jobs.mu.Lock()
+	w.j.output = append(w.j.output, bs...)
+	jobs.mu.Unlock()
+	if w.onOutput != nil {
+		w.onOutput(bs)
+	}
+	jobs.changed()
+	return len(bs), nil
+}
+
+// run runs cmd after all the jobs requested before it have finished,
+// onStart is called when cmd starts and onOutput for everything it writes
+// to standard output or standard error, both can be nil.
+func (jm *jobManager) run(cmd *exec.Cmd, onStart func(), onOutput func([]byte)) (*job, error) {
+	j := &job{Cmdline: strings.Join(cmd.Args, " "), Queued: time.Now(), cmd: cmd, cancelch: make(chan struct{})}
+	jm.mu.Lock()
+	jm.jobs = append(jm.jobs, j)
+	jm.mu.Unlock()
+	jm.changed()
+	defer jm.changed()
+
+	select {
+	case jm.sem <- struct{}{}:
+	case <-j.cancelch:
+		jm.mu.Lock()
+		j.State, j.Err, j.End = jobDone, errJobCancelled, time.Now()
+		jm.mu.Unlock()
+		return j, errJobCancelled
+	}
+	defer func() { <-jm.sem }()
+
+	jm.mu.Lock()
+	cancelled := j.cancelled
+	j.State, j.Start = jobRunning, time.Now()
+	w := &jobWriter{j, onOutput}
+	cmd.Stdout, cmd.Stderr = w, w
+	setProcessGroup(cmd)
+	var err error
+	if cancelled {
+		err = errJobCancelled
+	} else {
+		err = cmd.Start()
+	}
+	jm.mu.Unlock()
+
+	if onStart != nil && err == nil {
+		onStart()
+	}
+	if err == nil {
+		err = cmd.Wait()
+	}
+
+	jm.mu.Lock()
+	if j.cancelled {
+		err = errJobCancelled
+	}
+	j.State, j.Err, j.End = jobDone, err, time.Now()
+	jm.mu.Unlock()
+	return j, err
+}
+
+// cancel cancels a queued job or kills a running one, with everything it
+// started. Killing the editor or askpass helper closes its connection, which
+// closes the editor tab or the password popup it opened.
+func (jm *jobManager) cancel(j *job) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+	if j.State == jobDone || j.cancelled {
+		return
+	}
+	j.cancelled = true
+	switch {
+	case j.State == jobQueued:
+		close(j.cancelch)
+	case j.cmd.Process != nil:
+		killProcessGroup(j.cmd)
+	}
+}
+
+// active returns the queued and running jobs.
+func (jm *jobManager) active() []*job {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+	r := []*job{}
+	for _, j := range jm.jobs {
+		if j.State != jobDone {
+			r = append(r, j)
+		}
+	}
+	return r
+}
+
+func (jm *jobManager) changed() {
+	if jm.mw != nil {
+		jm.mw.Changed()
+	}
+}
+
+// runJob runs cmd as a job and returns its output.
+func runJob(cmd *exec.Cmd) (string, error) {
+	j, err := jobs.run(cmd, nil, nil)
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	return string(j.output), err
+}
+
+// showJobs shows the queued and running jobs with a button to cancel them.
+func showJobs(w *nucular.Window) {
+	for _, j := range jobs.active() {
+		w.Row(20).Static(0, 80)
+		jobs.mu.Lock()
+		state, cmdline := j.State, j.Cmdline
+		jobs.mu.Unlock()
+		if state == jobRunning {
+			w.Label("Running: "+cmdline, "LC")
+		} else {
+			w.Label("Queued: "+cmdline, "LC")
+		}
+		if w.ButtonText("Cancel") {
+			jobs.cancel(j)
+		}
+	}
+}
+
+type commandLogTab struct {
+	selected *job
+	shown    int // length of the output of the selected job in ed
+	ed       nucular.TextEditor
+	split    nucular.ScalableSplit
+}
+
+func newCommandLogTab() {
+	for _, tab := range tabs {
+		if ct, ok := tab.(*commandLogTab); ok {
+			currentTab = tabIndex(ct)
+			return
+		}
+	}
+	ct := &commandLogTab{}
+	ct.ed.Flags = nucular.EditSelectable | nucular.EditMultiline | nucular.EditFocusFollowsMouse | nucular.EditReadOnly | nucular.EditClipboard
+	ct.split.MinSize = 80
+	ct.split.Size = 200
+	ct.split.Spacing = 5
+	openTab(ct)
+}
+
+func (ct *commandLogTab) Title() string {
+	return "Command log"
+}
+
+func (ct *commandLogTab) Protected() bool {
+	return false
+}
+
+func (ct *commandLogTab) Update(w *nucular.Window) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+
+	style := w.Master().Style()
+	timesz := nucular.FontWidth(style.Font, "00:00:00") + style.Text.Padding.X*2
+	statussz := nucular.FontWidth(style.Font, "cancelled") + style.Text.Padding.X*2
+
+	area := w.Row(0).SpaceBegin(0)
+	listbounds, outbounds := ct.split.Horizontal(w, area)
+
+	w.LayoutSpacePushScaled(listbounds)
+	if sw := w.GroupBegin("command-log", nucular.WindowBorder|nucular.WindowNoHScrollbar); sw != nil {
+		sw.Row(20).StaticScaled(timesz, 0, statussz, statussz)
+		if len(jobs.jobs) == 0 {
+			sw.Row(20).Dynamic(1)
+			sw.Label("No commands", "LC")
+		}
+		for i := len(jobs.jobs) - 1; i >= 0; i-- {
+			j := jobs.jobs[i]
+			selected := ct.selected == j
+			sw.SelectableLabel(j.Queued.Format("15:04:05"), "LC", &selected)
+			sw.SelectableLabel(j.Cmdline, "LC", &selected)
+			sw.SelectableLabel(j.Status(), "LC", &selected)
+			sw.SelectableLabel(j.Duration().Round(time.Millisecond).String(), "RC", &selected)
+			if selected && ct.selected != j {
+				ct.selected = j
+				ct.shown = -1
+			}
+		}
+		sw.GroupEnd()
+	}
+
+	w.LayoutSpacePushScaled(outbounds)
+	if sw := w.GroupBegin("command-output", 0); sw != nil {
+		if ct.selected != nil {
+			if ct.shown != len(ct.selected.output) {
+				ct.shown = len(ct.selected.output)
+				ct.ed.Buffer = []rune(fmt.Sprintf("$ %s\n%s", ct.selected.Cmdline, ct.selected.output))
+			}
+			sw.Row(0).Dynamic(1)
+			ct.ed.Edit(sw)
+		}
+		sw.GroupEnd()
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func waitJobState(t *testing.T, j *job, state jobState) {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		jobs.mu.Lock()
+		s := j.State
+		jobs.mu.Unlock()
+		if s == state {
+			return
+		}
+	}
+	t.Fatalf("timeout waiting for %s", j.Cmdline)
+}
+
+func TestJobs(t *testing.T) {
+	out, err := runJob(exec.Command("sh", "-c", "echo out; echo err >&2; exit 3"))
+	if out != "out\nerr\n" || err == nil {
+		t.Errorf("wrong output %q %v", out, err)
+	}
+	j := jobs.jobs[len(jobs.jobs)-1]
+	if j.Status() != "exit 3" || j.Cmdline != "sh -c echo out; echo err >&2; exit 3" {
+		t.Errorf("wrong job %q %q", j.Status(), j.Cmdline)
+	}
+
+	// jobs run one at a time
+	n := len(jobs.jobs)
+	errs := make(chan error, 3)
+	go func() {
+		_, err := jobs.run(exec.Command("sleep", "10"), nil, nil)
+		errs <- err
+	}()
+	for len(jobs.active()) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	go func() {
+		_, err := jobs.run(exec.Command("true"), nil, nil)
+		errs <- err
+	}()
+	for len(jobs.active()) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	go func() {
+		_, err := jobs.run(exec.Command("true"), nil, nil)
+		errs <- err
+	}()
+	for len(jobs.active()) < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	jobs.mu.Lock()
+	sleep, queued, last := jobs.jobs[n], jobs.jobs[n+1], jobs.jobs[n+2]
+	jobs.mu.Unlock()
+	waitJobState(t, sleep, jobRunning)
+	if queued.State != jobQueued || last.State != jobQueued {
+		t.Fatalf("jobs not queued")
+	}
+
+	jobs.cancel(queued)
+	if err := <-errs; err != errJobCancelled {
+		t.Errorf("wrong error for cancelled queued job %v", err)
+	}
+	jobs.cancel(sleep)
+	// the last job can finish before the goroutine of the killed one
+	// returns
+	nilerrs := 0
+	for i := 0; i < 2; i++ {
+		switch err := <-errs; err {
+		case nil:
+			nilerrs++
+		case errJobCancelled:
+		default:
+			t.Errorf("unexpected error %v", err)
+		}
+	}
+	if nilerrs != 1 {
+		t.Errorf("wrong number of successful jobs %d", nilerrs)
+	}
+	if sleep.Status() != "cancelled" || queued.Status() != "cancelled" || last.Status() != "ok" || len(jobs.active()) != 0 {
+		t.Errorf("wrong status %q %q %q", sleep.Status(), queued.Status(), last.Status())
+	}
+	if sleep.Duration() >= 10*time.Second {
+		t.Errorf("running job was not killed")
+	}
+}
+
+func TestJobCancelChildren(t *testing.T) {
+	// the background sleep keeps the output of the job open
+	errs := make(chan error, 1)
+	go func() {
+		_, err := runJob(exec.Command("sh", "-c", "sleep 30 & wait"))
+		errs <- err
+	}()
+	for len(jobs.active()) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	j := jobs.active()[0]
+	waitJobState(t, j, jobRunning)
+	time.Sleep(50 * time.Millisecond)
+	jobs.cancel(j)
+	select {
+	case err := <-errs:
+		if err != errJobCancelled {
+			t.Errorf("wrong error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the children of the cancelled job were not killed")
+	}
+}
+
+func TestJobCancelEditor(t *testing.T) {
+	dir := testRepo(t)
+	defer os.RemoveAll(dir)
+
+	socname := filepath.Join(dir, ".git", "editor.test")
+	soc, err := net.Listen("unix", socname)
+	must(err)
+	defer soc.Close()
+	oldSocket := editorSocket
+	editorSocket = socname
+	defer func() { editorSocket = oldSocket }()
+
+	sessions := make(chan *editSession, 1)
+	go serveEditor(soc, func(req editorRequest, es *editSession) {
+		sessions <- es
+	})
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := runJob(editorCommand("git", "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--allow-empty"))
+		errs <- err
+	}()
+	var es *editSession
+	select {
+	case es = <-sessions:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for the editor request")
+	}
+
+	jobs.cancel(jobs.active()[0])
+	select {
+	case err := <-errs:
+		if err != errJobCancelled {
+			t.Errorf("wrong error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for the cancelled job")
+	}
+	select {
+	case <-es.done:
+		if !es.gone() {
+			t.Errorf("editor request answered instead of cancelled")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("editor request not cancelled")
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"os/exec"
+)
+
+// setProcessGroup does nothing on this platform, only the command itself is
+// killed when a job is cancelled.
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
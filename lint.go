@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Commit messages are checked while they are written, the checks are
+// configured for each repository with git config:
+//
+//	fkgit.template           commit template, used instead of commit.template
+//	fkgit.lintSubjectLength  maximum length of the subject (default 72, 0 disables)
+//	fkgit.lintPrefix         regular expression the subject must match (for
+//	                         example "^[a-z0-9/]+: ")
+//	fkgit.lintConventional   check that the subject follows Conventional Commits
+//	fkgit.lintBlocking       comma separated list of rules that prevent the
+//	                         commit, or "all"
+
+const (
+	lintSubjectLength  = "subject-length"
+	lintBlankLine      = "blank-line"
+	lintTrailingPeriod = "trailing-period"
+	lintPrefix         = "prefix"
+	lintConventional   = "conventional"
+)
+
+var conventionalRx = regexp.MustCompile(`^(build|chore|ci|docs|feat|fix|perf|refactor|revert|style|test)(\([^()]+\))?!?: \S`)
+
+type lintConfig struct {
+	subjectLength int
+	prefix        *regexp.Regexp
+	conventional  bool
+	blocking      map[string]bool
+	stripComments bool // lines starting with # are not part of the message
+}
+
+type lintWarning struct {
+	rule string
+	msg  string
+}
+
+// loadLintConfig reads the lint configuration of the repository, invalid
+// settings are reported as errors but do not prevent the others from being
+// used.
+func loadLintConfig() (*lintConfig, []error) {
+	cfg := &lintConfig{subjectLength: 72, blocking: map[string]bool{}}
+	var errs []error
+
+	out, _ := execCommand("git", "config", "-z", "--get-regexp", `^fkgit\.lint`)
+	for _, rec := range strings.Split(out, "\x00") {
+		if rec == "" {
+			continue
+		}
+		// keys without a value are printed without the newline
+		v := strings.SplitN(rec, "\n", 2)
+		key, val := v[0], ""
+		if len(v) == 2 {
+			val = v[1]
+		}
+		switch key {
+		case "fkgit.lintsubjectlength":
+			n, err := strconv.Atoi(strings.TrimSpace(val))
+			if err != nil {
+				errs = append(errs, fmt.Errorf("fkgit.lintSubjectLength: %v", err))
+				continue
+			}
+			cfg.subjectLength = n
+		case "fkgit.lintprefix":
+			rx, err := regexp.Compile(val)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("fkgit.lintPrefix: %v", err))
+				continue
+			}
+			cfg.prefix = rx
+		case "fkgit.lintconventional":
+			cfg.conventional = gitBool(val)
+		case "fkgit.lintblocking":
+			for _, rule := range strings.Split(val, ",") {
+				cfg.blocking[strings.TrimSpace(rule)] = true
+			}
+		}
+	}
+	return cfg, errs
+}
+
+func gitBool(s string) bool {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "true", "yes", "on", "1", "":
+		return true
+	}
+	return false
+}
+
+func (cfg *lintConfig) isBlocking(rule string) bool {
+	return cfg.blocking["all"] || cfg.blocking[rule]
+}
+
+// lintCommitMsg checks msg against the rules enabled in cfg.
+func lintCommitMsg(msg string, cfg *lintConfig) []lintWarning {
+	lines := strings.Split(msg, "\n")
+	if cfg.stripComments {
+		out := lines[:0:0]
+		for _, line := range lines {
+			if !strings.HasPrefix(line, "#") {
+				out = append(out, line)
+			}
+		}
+		lines = out
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	var r []lintWarning
+	warn := func(rule, format string, args ...interface{}) {
+		r = append(r, lintWarning{rule, fmt.Sprintf(format, args...)})
+	}
+
+	subject := strings.TrimRight(lines[0], " \t")
+	if n := len([]rune(subject)); cfg.subjectLength > 0 && n > cfg.subjectLength {
+		warn(lintSubjectLength, "Subject is %d characters long (maximum %d)", n, cfg.subjectLength)
+	}
+	if len(lines) > 1 && strings.TrimSpace(lines[1]) != "" {
+		warn(lintBlankLine, "Missing blank line after the subject")
+	}
+	if strings.HasSuffix(subject, ".") {
+		warn(lintTrailingPeriod, "Subject ends with a period")
+	}
+
+	// subjects generated by git are not checked against the format rules
+	for _, s := range []string{"Merge ", "Revert \"", "fixup! ", "squash! ", "amend! "} {
+		if strings.HasPrefix(subject, s) {
+			return r
+		}
+	}
+	if cfg.prefix != nil && !cfg.prefix.MatchString(subject) {
+		warn(lintPrefix, "Subject does not match %q", cfg.prefix.String())
+	}
+	if cfg.conventional && !conventionalRx.MatchString(subject) {
+		warn(lintConventional, "Subject is not in the form \"type(scope): description\"")
+	}
+	return r
+}
+
+// commitTemplate returns the contents of the commit template configured
+// for the repository, if any.
+func commitTemplate() (string, bool) {
+	path, err := execCommand("git", "config", "--path", "--get", "fkgit.template")
+	if err != nil {
+		path, err = execCommand("git", "config", "--path", "--get", "commit.template")
+	}
+	path = strings.TrimSpace(path)
+	if err != nil || path == "" {
+		return "", false
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(Repodir, path)
+	}
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	return string(bs), true
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"testing"
+)
+
+func TestLintCommitMsg(t *testing.T) {
+	rules := func(ws []lintWarning) string {
+		r := []string{}
+		for _, w := range ws {
+			r = append(r, w.rule)
+		}
+		return fmt.Sprint(r)
+	}
+
+	cfg := &lintConfig{subjectLength: 20}
+	for _, tc := range []struct {
+		msg, rules string
+	}{
+		{"", "[]"},
+		{"short subject\n\nbody\n", "[]"},
+		{"a subject longer than twenty", "[subject-length]"},
+		{"subject.\nbody", "[blank-line trailing-period]"},
+		{"\n\nsubject\n", "[]"},
+	} {
+		if out := rules(lintCommitMsg(tc.msg, cfg)); out != tc.rules {
+			t.Errorf("%q: got %s expected %s", tc.msg, out, tc.rules)
+		}
+	}
+
+	cfg = &lintConfig{prefix: regexp.MustCompile(`^[a-z0-9/]+: `), conventional: true}
+	for _, tc := range []struct {
+		msg, rules string
+	}{
+		{"fix: something", "[]"},
+		{"feat(ui)!: something", "[prefix]"},
+		{"ui: something", "[conventional]"},
+		{"Something", "[prefix conventional]"},
+		{"Merge branch 'x'", "[]"},
+		{"fixup! Something", "[]"},
+	} {
+		if out := rules(lintCommitMsg(tc.msg, cfg)); out != tc.rules {
+			t.Errorf("%q: got %s expected %s", tc.msg, out, tc.rules)
+		}
+	}
+
+	cfg = &lintConfig{stripComments: true}
+	if out := rules(lintCommitMsg("# comment\nsubject\n# comment\n\nbody.", cfg)); out != "[]" {
+		t.Errorf("comments: got %s", out)
+	}
+}
+
+func TestLoadLintConfig(t *testing.T) {
+	dir := testRepo(t)
+	defer os.RemoveAll(dir)
+
+	testGit(t, "config", "fkgit.lintSubjectLength", "50")
+	testGit(t, "config", "fkgit.lintPrefix", "^[a-z]+: ")
+	testGit(t, "config", "fkgit.lintBlocking", "prefix, blank-line")
+	testWrite(t, ".git/config", testRead(t, ".git/config")+"\tlintConventional\n")
+
+	cfg, errs := loadLintConfig()
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if cfg.subjectLength != 50 || cfg.prefix.String() != "^[a-z]+: " || !cfg.conventional {
+		t.Errorf("wrong configuration %#v", cfg)
+	}
+	if !cfg.isBlocking(lintPrefix) || !cfg.isBlocking(lintBlankLine) || cfg.isBlocking(lintSubjectLength) {
+		t.Errorf("wrong blocking rules %v", cfg.blocking)
+	}
+
+	testGit(t, "config", "fkgit.lintPrefix", "(")
+	if _, errs := loadLintConfig(); len(errs) != 1 {
+		t.Errorf("invalid regexp not reported: %v", errs)
+	}
+
+	if _, ok := commitTemplate(); ok {
+		t.Errorf("unexpected template")
+	}
+	testWrite(t, "tmpl", "subject\n# comment\n")
+	testGit(t, "config", "commit.template", "tmpl")
+	if tmpl, ok := commitTemplate(); !ok || tmpl != "subject\n# comment\n" {
+		t.Errorf("wrong template %q", tmpl)
+	}
+}
@@ -191,11 +191,11 @@ headerLoop:
 	return
 }
 
-func allCommits(commitRange string) *CommitFetcher {
+func allCommits(revs ...string) *CommitFetcher {
 	fetcher := &CommitFetcher{}
 	outchan := make(chan Commit)
 	fetcher.Out = outchan
-	args := []string{"log", "--pretty=raw", "-z", "--no-color", "--date-order", commitRange}
+	args := append([]string{"log", "--pretty=raw", "-z", "--no-color", "--date-order"}, revs...)
 	fetcher.cmd = exec.Command("git", args...)
 	fetcher.cmd.Dir = Repodir
 	stdout, err := fetcher.cmd.StdoutPipe()
@@ -465,19 +465,27 @@ type LogWindow struct {
 	commits     []LanedCommit
 	maxOccupied int
 
-	Headisref bool
-	Head      *Ref
-	status    *GitStatus
+	Headisref     bool
+	Head          *Ref
+	status        *GitStatus
+	statusSummary string
+	statusLoading bool
+	statusGen     int
 
 	needsMore int
 	done      bool
 	started   bool
 
+	refreshing     bool // refreshproc is running
+	pendingRefresh bool // the references changed while the graph was loading
+
 	selectedId   string
 	selectedView *ViewWindow
 
-	allrefs []Ref
-	mw      nucular.MasterWindow
+	allrefs     []Ref
+	fingerprint string         // refsFingerprint when the graph was loaded
+	newCommits  map[string]int // new commits of remote-tracking branches fetched in the background
+	mw          nucular.MasterWindow
 
 	searchCmd     *exec.Cmd
 	searchMode    searchMode
@@ -504,10 +512,22 @@ const (
 
 func (lw *LogWindow) commitproc() {
 	defer func() {
+		lw.mu.Lock()
 		lw.done = true
+		if lw.pendingRefresh && !lw.refreshing {
+			lw.pendingRefresh = false
+			lw.refreshing = true
+			go lw.refreshproc()
+		}
+		lw.mu.Unlock()
 		lw.mw.Changed()
 	}()
 
+	fingerprint := refsFingerprint()
+	lw.mu.Lock()
+	lw.fingerprint = fingerprint
+	lw.mu.Unlock()
+
 	var err error
 	lw.allrefs, err = allRefs()
 	if err != nil {
@@ -515,7 +535,7 @@ func (lw *LogWindow) commitproc() {
 		return
 	}
 
-	fetcher := allCommits("--all")
+	fetcher := allCommits(allRevs...)
 	commitchan := make(chan LanedCommit)
 	var headcommit string
 	lw.Headisref, headcommit, _ = getHead()
@@ -601,6 +621,9 @@ func (lw *LogWindow) selectCommit(lc *LanedCommit) {
 		return
 	}
 	lw.selectedId = lc.Id
+	for i := range lc.Refs {
+		delete(lw.newCommits, lc.Refs[i].Name)
+	}
 	lw.showOutput = false
 	lw.selectedView = NewViewWindow(lc.Commit, false)
 }
@@ -608,6 +631,7 @@ func (lw *LogWindow) selectCommit(lc *LanedCommit) {
 var graphColor = color.RGBA{213, 204, 255, 0xff}
 var refsColor = color.RGBA{255, 182, 97, 0xff}
 var refsHeadColor = color.RGBA{233, 255, 97, 0xff}
+var refsNewColor = color.RGBA{97, 255, 182, 0xff}
 
 func (lw *LogWindow) UpdateGraph(w *nucular.Window) {
 	lw.mu.Lock()
@@ -630,10 +654,14 @@ func (lw *LogWindow) UpdateGraph(w *nucular.Window) {
 			w.Row(25).Static(0, 100, 100, 100, 100)
 		}
 	}
-	if lw.status == nil {
-		lw.status = gitStatus()
+	if lw.status == nil && !lw.statusLoading {
+		lw.statusLoading = true
+		if lw.statusSummary == "" {
+			lw.statusSummary = "Loading status..."
+		}
+		go lw.loadStatus(lw.statusGen)
 	}
-	w.Label(lw.status.Summary(), "LC")
+	w.Label(lw.statusSummary, "LC")
 
 	switch lw.searchMode {
 	case noSearch:
@@ -887,8 +915,13 @@ func (lw *LogWindow) UpdateGraph(w *nucular.Window) {
 
 		if len(lc.Refs) != 0 || lc.IsHEAD {
 			var buf bytes.Buffer
+			hasnew := false
 			for i := range lc.Refs {
 				io.WriteString(&buf, lc.Refs[i].Nice())
+				if n, ok := lw.newCommits[lc.Refs[i].Name]; ok {
+					fmt.Fprintf(&buf, " (%d new)", n)
+					hasnew = true
+				}
 				if i != len(lc.Refs)-1 {
 					io.WriteString(&buf, ", ")
 				}
@@ -911,9 +944,12 @@ func (lw *LogWindow) UpdateGraph(w *nucular.Window) {
 
 			if refsz > 0 {
 				w.LayoutSetWidthScaled(refsz)
-				if ishead {
+				switch {
+				case ishead:
 					w.LabelColored(refstr, "LC", refsHeadColor)
-				} else {
+				case hasnew:
+					w.LabelColored(refstr, "LC", refsNewColor)
+				default:
 					w.LabelColored(refstr, "LC", refsColor)
 				}
 			}
@@ -1174,6 +1210,10 @@ func (cm *commitMenu) Update(w *nucular.Window) {
 		newNewBranchPopup(lw.mw, lc.Id)
 	}
 
+	if w.MenuItem(label.TA("New tag...", "LC")) {
+		newNewTagPopup(lw.mw, lc.Id)
+	}
+
 	if lw.Headisref {
 		if w.MenuItem(label.TA(fmt.Sprintf("Reset %s here", lw.Head.Nice()), "LC")) {
 			newResetPopup(cm.mainw, lc.Id, resetHard)
@@ -1247,6 +1287,9 @@ func (cm *commitMenu) Update(w *nucular.Window) {
 				rebaseAction(lw, lc.Id)
 			}
 		}
+		if w.MenuItem(label.TA("Rebase...", "LC")) {
+			newRebasePopup(lw.mw, lw.allrefs, lc)
+		}
 	}
 
 	if w.MenuItem(label.TA("Diff", "LC")) {
@@ -1299,6 +1342,26 @@ func (lw *LogWindow) Update(w *nucular.Window) {
 	}
 }
 
+// loadStatus loads the status of the repository in the background,
+// the result is discarded if reload was called in the meantime.
+func (lw *LogWindow) loadStatus(gen int) {
+	status, err := gitStatus()
+
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	lw.statusLoading = false
+	if gen == lw.statusGen {
+		if err != nil {
+			status = &GitStatus{}
+			lw.statusSummary = strings.SplitN(err.Error(), "\n", 2)[0]
+		} else {
+			lw.statusSummary = status.Summary()
+		}
+		lw.status = status
+	}
+	lw.mw.Changed()
+}
+
 func (lw *LogWindow) reload() {
 	if lw.started && !lw.done {
 		return
@@ -1315,6 +1378,87 @@ func (lw *LogWindow) reload() {
 	lw.done = false
 	lw.started = false
 	lw.status = nil
+	lw.statusGen++
+}
+
+// refresh reloads the status and, if fingerprint is not empty and
+// different from the one of the loaded graph, the graph keeping the
+// selected commit and the scroll position. If the graph is being loaded
+// the refresh happens when loading finishes. Must be called with lw.mu
+// held.
+func (lw *LogWindow) refresh(fingerprint string) {
+	if fingerprint == "" || fingerprint == lw.fingerprint {
+		lw.status = nil
+		lw.statusGen++
+		return
+	}
+	switch {
+	case !lw.started:
+		// the graph will be loaded from scratch
+	case !lw.done || lw.refreshing:
+		lw.pendingRefresh = true
+	default:
+		lw.refreshing = true
+		go lw.refreshproc()
+	}
+}
+
+// refreshproc loads the graph again in the background and replaces the
+// loaded one when it is complete, so that the selected commit and the
+// scroll position are kept.
+func (lw *LogWindow) refreshproc() {
+	for {
+		fingerprint := refsFingerprint()
+		allrefs, err := allRefs()
+		if err != nil {
+			lw.mu.Lock()
+			lw.refreshing, lw.pendingRefresh = false, false
+			lw.mu.Unlock()
+			newMessagePopup(lw.mw, "Error", fmt.Sprintf("Error fetching references: %v\n", err))
+			return
+		}
+
+		fetcher := allCommits(allRevs...)
+		commitchan := make(chan LanedCommit)
+		headisref, headcommit, _ := getHead()
+		var head *Ref
+		if headisref {
+			head = &Ref{}
+			head.Init(headcommit, "")
+			headcommit = ""
+		}
+		go laneCommits(headcommit, allrefs, fetcher.Out, commitchan)
+
+		commits := []LanedCommit{}
+		maxOccupied := 1
+		for commit := range commitchan {
+			commits = append(commits, commit)
+			if occupied := commit.Occupied(); occupied > maxOccupied {
+				maxOccupied = occupied
+			}
+		}
+		if fetcher.Err != nil {
+			newMessagePopup(lw.mw, "Error", fmt.Sprintf("Error fetching commits: %v\n", fetcher.Err))
+		}
+
+		lw.mu.Lock()
+		if lw.started && lw.done {
+			// otherwise the graph was reloaded from scratch in the meantime
+			lw.commits, lw.maxOccupied = commits, maxOccupied
+			lw.allrefs, lw.Headisref, lw.Head = allrefs, headisref, head
+			lw.fingerprint = fingerprint
+			lw.status = nil
+			lw.statusGen++
+		}
+		pending := lw.pendingRefresh
+		lw.pendingRefresh = false
+		lw.refreshing = pending
+		lw.mu.Unlock()
+		lw.mw.Changed()
+		if !pending {
+			return
+		}
+	}
 }
 
 func (lw *LogWindow) pathSearch(path string) {
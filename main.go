@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"image"
 	"io"
@@ -36,6 +37,24 @@ func execCommand(cmdname string, args ...string) (string, error) {
 	return string(bs), err
 }
 
+func execCommandContext(ctx context.Context, cmdname string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, cmdname, args...)
+	cmd.Dir = Repodir
+	bs, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	return string(bs), err
+}
+
+func execCommandStdin(stdin string, cmdname string, args ...string) (string, error) {
+	cmd := exec.Command(cmdname, args...)
+	cmd.Dir = Repodir
+	cmd.Stdin = strings.NewReader(stdin)
+	bs, err := cmd.CombinedOutput()
+	return string(bs), err
+}
+
 type RefKind int
 
 const (
@@ -112,6 +131,13 @@ func getHead() (isref bool, reforid string, err error) {
 	return false, s, nil
 }
 
+// privateRefsPrefix is the namespace of the references fkgit keeps for
+// itself, they are not shown to the user.
+const privateRefsPrefix = "refs/fkgit/"
+
+// allRevs selects every commit reachable from the user's references.
+var allRevs = []string{"--exclude=" + privateRefsPrefix + "*", "--all"}
+
 func allRefs() ([]Ref, error) {
 	headisref, headref, err := getHead()
 	if err != nil {
@@ -135,16 +161,20 @@ func allRefs() ([]Ref, error) {
 			continue
 		}
 		commitidend := strings.Index(v[i], " ")
+		if strings.HasPrefix(v[i][commitidend+1:], privateRefsPrefix) {
+			continue
+		}
 		var ref Ref
 		ref.Init(v[i][commitidend+1:], v[i][:commitidend])
-		if ref.Kind == TagRef {
-			if strings.HasSuffix(ref.Name, realTagSuffix) {
-				ref.Name = ref.Name[:len(ref.Name)-len(realTagSuffix)]
-				ref.Init(ref.Name, ref.CommitId)
-			} else {
-				// skip this tag the ^{} version will be following it
+		if ref.Kind == TagRef && strings.HasSuffix(ref.Name, realTagSuffix) {
+			// annotated tag, the ^{} line follows the line of the tag
+			// object and has the commit it points to
+			name := ref.Name[:len(ref.Name)-len(realTagSuffix)]
+			if len(r) > 0 && r[len(r)-1].Name == name {
+				r[len(r)-1].CommitId = ref.CommitId
 				continue
 			}
+			ref.Init(name, ref.CommitId)
 		}
 		if ref.Name == headref {
 			ref.IsHEAD = true
@@ -155,10 +185,22 @@ func allRefs() ([]Ref, error) {
 	return r, nil
 }
 
-func allRemotes() map[string]string {
-	r := map[string]string{}
+type Remote struct {
+	Name     string
+	FetchURL string
+	PushURL  string
+}
+
+func allRemotes() map[string]Remote {
 	s, err := execCommand("git", "remote", "-v")
 	must(err)
+	return parseRemotes(s)
+}
+
+// parseRemotes parses the output of git remote -v, each remote has a line
+// for the fetch URL and a line for the push URL.
+func parseRemotes(s string) map[string]Remote {
+	r := map[string]Remote{}
 	rlines := strings.Split(s, "\n")
 	for _, line := range rlines {
 		fields := strings.Split(line, "\t")
@@ -168,7 +210,15 @@ func allRemotes() map[string]string {
 		name := fields[0]
 		fields = strings.Split(fields[1], " ")
 		url := fields[0]
-		r[name] = url
+		remote := r[name]
+		remote.Name = name
+		switch fields[len(fields)-1] {
+		case "(push)":
+			remote.PushURL = url
+		default:
+			remote.FetchURL = url
+		}
+		r[name] = remote
 	}
 	return r
 }
@@ -394,6 +444,7 @@ func guiUpdate(w *nucular.Window) {
 			case indexTabIndex:
 				idxmw.reload()
 			}
+			checkSequencerStateAsync(mw)
 
 		case (e.Modifiers == key.ModControl) && (e.Code == key.CodeW):
 			if tabs[currentTab].Protected() {
@@ -410,6 +461,9 @@ func guiUpdate(w *nucular.Window) {
 		}
 	}
 
+	showNotifications(w)
+	showJobs(w)
+
 	closetab := -1
 	tabwidths := make([]int, len(tabs)+1)
 	tabwidths[0] = 90
@@ -420,10 +474,25 @@ func guiUpdate(w *nucular.Window) {
 	if w := w.Menu(label.TA("More...", "CC"), 200, nil); w != nil {
 		w.Row(20).Dynamic(1)
 		if w.MenuItem(label.TA("Remotes", "LC")) {
-			newRemotesTab()
+			newRemotesTab(mw)
 		}
 		if w.MenuItem(label.TA("Refs", "LC")) {
-			newRefsTab()
+			newRefsTab(mw)
+		}
+		if w.MenuItem(label.TA("Stashes", "LC")) {
+			newStashTab(mw)
+		}
+		if w.MenuItem(label.TA("Recently discarded", "LC")) {
+			newDiscardedTab(mw)
+		}
+		if w.MenuItem(label.TA("Clean...", "LC")) {
+			newCleanPopup(mw)
+		}
+		if w.MenuItem(label.TA("Command log", "LC")) {
+			newCommandLogTab()
+		}
+		if w.MenuItem(label.TA("Background fetch...", "LC")) {
+			newAutoFetchPopup(mw)
 		}
 		if githubStuff != nil {
 			if w.MenuItem(label.TA("Github Issues", "LC")) {
@@ -467,6 +536,12 @@ func main() {
 
 	blamefile, blamerev := "", ""
 
+	if os.Getenv(askpassEnv) != "" && os.Getenv(editorSocketEnv) != "" && len(os.Args) <= 2 {
+		// started by git or ssh as GIT_ASKPASS or SSH_ASKPASS
+		askpassMain()
+		return
+	}
+
 	if len(os.Args) >= 2 {
 		switch os.Args[1] {
 		case "help":
@@ -478,7 +553,7 @@ func main() {
 			fmt.Printf("Call without arguments to open log/commit window\n")
 			os.Exit(0)
 		case "seqed", "comed":
-			if os.Getenv("FKGIT_SEQUENCE_EDITOR_SOCKET") == "" {
+			if os.Getenv(editorSocketEnv) == "" {
 				fmt.Fprintf(os.Stderr, "no sequence editor socket\n")
 				os.Exit(1)
 			}
@@ -529,10 +604,17 @@ func main() {
 	lw.split.MinSize = 20
 	lw.split.Spacing = 5
 	lw.mw = wnd
+	lw.newCommits = map[string]int{}
+	jobs.mw = wnd
+
+	if err := startEditorServer(wnd); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
 
 	openTab(&lw)
 
 	idxmw.selected = -1
+	idxmw.status = &GitStatus{}
 	idxmw.splitv.MinSize = 80
 	idxmw.splitv.Size = 200
 	idxmw.splitv.Spacing = 5
@@ -542,10 +624,24 @@ func main() {
 	idxmw.mw = wnd
 	idxmw.fmtwidth = 70
 	idxmw.ed.Flags = nucular.EditSelectable | nucular.EditMultiline | nucular.EditFocusFollowsMouse | nucular.EditClipboard
+	idxmw.opts.init()
+	idxmw.commitOutput.Flags = nucular.EditSelectable | nucular.EditMultiline | nucular.EditFocusFollowsMouse | nucular.EditReadOnly | nucular.EditClipboard
+	idxmw.history = loadMsgHistory()
+	idxmw.historyIdx = -1
+	idxmw.treeView = true
+	idxmw.filter.hide[categoryIgnored] = true
+	idxmw.collapsed = map[string]bool{}
+	idxmw.filterEd.Flags = nucular.EditSelectable | nucular.EditFocusFollowsMouse | nucular.EditClipboard
 	idxmw.reload()
 
 	openTab(&idxmw)
 
+	seqTabIndex := -1
+	// the window is not running yet, the UI lock is not needed
+	if checkSequencerState(wnd, loadSequencerStatus()) {
+		seqTabIndex = len(tabs) - 1
+	}
+
 	blameTabIndex := -1
 	if blamefile != "" {
 		wd, _ := os.Getwd()
@@ -559,14 +655,32 @@ func main() {
 
 	initGithubIntegration(wnd)
 
-	status := gitStatus()
+	if err := startWatcher(wnd); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+	go autoFetchLoop(wnd)
+
 	switch {
 	case blameTabIndex >= 0:
 		currentTab = blameTabIndex
-	case len(status.Lines) != 0:
-		currentTab = indexTabIndex
+	case seqTabIndex >= 0:
+		currentTab = seqTabIndex
 	default:
 		currentTab = graphTabIndex
+		go func() {
+			// switch to the commit tab if there are changes, unless the
+			// user already moved to a different tab
+			status, err := gitStatus()
+			if err != nil || len(status.Lines) == 0 {
+				return
+			}
+			wnd.Lock()
+			defer wnd.Unlock()
+			if currentTab == graphTabIndex {
+				currentTab = indexTabIndex
+			}
+			wnd.Changed()
+		}()
 	}
 
 	wnd.Main()
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aarzilli/nucular"
+	"github.com/aarzilli/nucular/label"
+	"github.com/aarzilli/nucular/rect"
+)
+
+// Commit messages written in the Commit tab are saved in
+// .git/fkgit-messages, both the ones that were committed and drafts that
+// would otherwise be lost (failed commits, messages replaced by switching
+// to amend or by picking an older message).
+
+const maxMsgHistory = 50
+
+type msgHistoryEntry struct {
+	Message   string
+	When      time.Time
+	Committed bool
+}
+
+func msgHistoryPath() string {
+	return filepath.Join(Repodir, ".git", "fkgit-messages")
+}
+
+func loadMsgHistory() []msgHistoryEntry {
+	fh, err := os.Open(msgHistoryPath())
+	if err != nil {
+		return nil
+	}
+	defer fh.Close()
+	var r []msgHistoryEntry
+	json.NewDecoder(fh).Decode(&r)
+	return r
+}
+
+func saveMsgHistory(history []msgHistoryEntry) error {
+	fh, err := os.Create(msgHistoryPath())
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	return json.NewEncoder(fh).Encode(history)
+}
+
+// addMsgHistory adds msg at the start of history, removing older copies
+// of it. Empty messages are not saved.
+func addMsgHistory(history []msgHistoryEntry, msg string, committed bool, now time.Time) []msgHistoryEntry {
+	if strings.TrimSpace(msg) == "" {
+		return history
+	}
+	r := make([]msgHistoryEntry, 0, len(history)+1)
+	r = append(r, msgHistoryEntry{Message: msg, When: now, Committed: committed})
+	for _, e := range history {
+		if strings.TrimSpace(e.Message) != strings.TrimSpace(msg) {
+			r = append(r, e)
+		}
+	}
+	if len(r) > maxMsgHistory {
+		r = r[:maxMsgHistory]
+	}
+	return r
+}
+
+func (e *msgHistoryEntry) describe() string {
+	subject := strings.TrimSpace(e.Message)
+	if i := strings.Index(subject, "\n"); i >= 0 {
+		subject = subject[:i]
+	}
+	kind := "draft"
+	if e.Committed {
+		kind = "committed"
+	}
+	return fmt.Sprintf("%s %s: %s", e.When.Format("2006-01-02 15:04"), kind, subject)
+}
+
+// gitIdentities returns the authors of the repository, after applying the
+// mailmap, most active first.
+func gitIdentities() ([]string, error) {
+	out, err := execCommand("git", append([]string{"shortlog", "-s", "-n", "-e"}, allRevs...)...)
+	if err != nil {
+		return nil, fmt.Errorf("git shortlog: %v\n%s", err, out)
+	}
+	r := []string{}
+	for _, line := range strings.Split(out, "\n") {
+		if i := strings.Index(line, "\t"); i >= 0 {
+			r = append(r, strings.TrimSpace(line[i+1:]))
+		}
+	}
+	return r, nil
+}
+
+// addCoAuthors adds a Co-authored-by trailer to msg for each identity.
+func addCoAuthors(msg string, idents []string) (string, error) {
+	args := []string{"interpret-trailers", "--if-exists", "addIfDifferent"}
+	for _, ident := range idents {
+		args = append(args, "--trailer", "Co-authored-by: "+ident)
+	}
+	if !strings.HasSuffix(msg, "\n") {
+		msg += "\n"
+	}
+	out, err := execCommandStdin(msg, "git", args...)
+	if err != nil {
+		return msg, fmt.Errorf("git interpret-trailers: %v\n%s", err, out)
+	}
+	return out, nil
+}
+
+// saveDraft saves the message being written to the history, must be
+// called with idxmw.mu held.
+func (idxmw *IndexManagerWindow) saveDraft() {
+	msg := string(idxmw.ed.Buffer)
+	if msg == idxmw.template {
+		return
+	}
+	idxmw.addHistory(msg, false)
+}
+
+func (idxmw *IndexManagerWindow) addHistory(msg string, committed bool) {
+	idxmw.history = addMsgHistory(idxmw.history, msg, committed, time.Now())
+	idxmw.historyIdx = -1
+	saveMsgHistory(idxmw.history)
+}
+
+func (idxmw *IndexManagerWindow) setMessage(msg string) {
+	idxmw.ed.Buffer = []rune(msg)
+	idxmw.templateLoaded = idxmw.template != "" && msg == idxmw.template
+	idxmw.ed.Cursor = len(idxmw.ed.Buffer)
+	idxmw.ed.SelectStart, idxmw.ed.SelectEnd = idxmw.ed.Cursor, idxmw.ed.Cursor
+}
+
+// historyMove replaces the message with an older (delta > 0) or newer
+// (delta < 0) message from the history, moving past the most recent
+// message goes back to the message that was being written.
+func (idxmw *IndexManagerWindow) historyMove(delta int) {
+	n := idxmw.historyIdx + delta
+	if n < -1 || n >= len(idxmw.history) {
+		return
+	}
+	if idxmw.historyIdx == -1 {
+		idxmw.historyDraft = string(idxmw.ed.Buffer)
+	}
+	idxmw.historyIdx = n
+	if n == -1 {
+		idxmw.setMessage(idxmw.historyDraft)
+	} else {
+		idxmw.setMessage(idxmw.history[n].Message)
+	}
+}
+
+func (idxmw *IndexManagerWindow) historyMenu(w *nucular.Window) {
+	idxmw.mu.Lock()
+	defer idxmw.mu.Unlock()
+	w.Row(20).Dynamic(1)
+	if w.MenuItem(label.TA("Add co-author...", "LC")) {
+		newCoAuthorPopup(idxmw.mw)
+	}
+	if len(idxmw.history) == 0 {
+		w.Label("No recent messages", "LC")
+	}
+	for i := range idxmw.history {
+		if w.MenuItem(label.TA(idxmw.history[i].describe(), "LC")) {
+			msg := idxmw.history[i].Message
+			idxmw.saveDraft()
+			idxmw.setMessage(msg)
+		}
+	}
+}
+
+type coAuthorPopup struct {
+	idents   []string
+	err      error
+	loaded   bool
+	selected map[string]bool
+	filterEd nucular.TextEditor
+}
+
+func newCoAuthorPopup(mw nucular.MasterWindow) {
+	cp := &coAuthorPopup{selected: map[string]bool{}}
+	cp.filterEd.Flags = nucular.EditSelectable | nucular.EditFocusFollowsMouse | nucular.EditClipboard
+	cp.filterEd.Active = true
+	go func() {
+		idents, err := gitIdentities()
+		mw.Lock()
+		cp.idents, cp.err, cp.loaded = idents, err, true
+		mw.Unlock()
+		mw.Changed()
+	}()
+	mw.PopupOpen("Add co-authors...", popupFlags, rect.Rect{20, 100, 480, 500}, true, cp.Update)
+}
+
+func (cp *coAuthorPopup) Update(w *nucular.Window) {
+	w.Row(25).Static(50, 0)
+	w.Label("Filter:", "LC")
+	cp.filterEd.Edit(w)
+
+	w.Row(300).Dynamic(1)
+	if sw := w.GroupBegin("coauthors-list", nucular.WindowBorder|nucular.WindowNoHScrollbar); sw != nil {
+		sw.Row(20).Dynamic(1)
+		switch {
+		case !cp.loaded:
+			sw.Label("Loading...", "LC")
+		case cp.err != nil:
+			sw.Label(strings.SplitN(cp.err.Error(), "\n", 2)[0], "LC")
+		}
+		filter := strings.ToLower(string(cp.filterEd.Buffer))
+		for _, ident := range cp.idents {
+			if !strings.Contains(strings.ToLower(ident), filter) {
+				continue
+			}
+			checked := cp.selected[ident]
+			if sw.CheckboxText(ident, &checked) {
+				cp.selected[ident] = checked
+			}
+		}
+		sw.GroupEnd()
+	}
+
+	ok, _ := okCancelButtons(w, true, "Add", true)
+	if ok {
+		idents := []string{}
+		for _, ident := range cp.idents {
+			if cp.selected[ident] {
+				idents = append(idents, ident)
+			}
+		}
+		sort.Strings(idents)
+		if len(idents) > 0 {
+			go idxmw.insertCoAuthors(idents)
+		}
+	}
+}
+
+// insertCoAuthors adds co-author trailers to the commit message.
+func (idxmw *IndexManagerWindow) insertCoAuthors(idents []string) {
+	idxmw.mu.Lock()
+	msg := string(idxmw.ed.Buffer)
+	idxmw.mu.Unlock()
+
+	out, err := addCoAuthors(msg, idents)
+
+	idxmw.mu.Lock()
+	defer idxmw.mu.Unlock()
+	if err != nil {
+		newMessagePopup(idxmw.mw, "Error", fmt.Sprintf("Error: %v\n", err))
+		return
+	}
+	if string(idxmw.ed.Buffer) != msg {
+		// the message was changed in the meantime
+		return
+	}
+	idxmw.setMessage(out)
+	idxmw.mw.Changed()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAddMsgHistory(t *testing.T) {
+	now := time.Now()
+	var h []msgHistoryEntry
+	h = addMsgHistory(h, "first", true, now)
+	h = addMsgHistory(h, "  \n", false, now)
+	h = addMsgHistory(h, "second", false, now)
+	h = addMsgHistory(h, "first\n", false, now)
+	if len(h) != 2 || h[0].Message != "first\n" || h[0].Committed || h[1].Message != "second" {
+		t.Errorf("wrong history %v", h)
+	}
+
+	for i := 0; i < maxMsgHistory+10; i++ {
+		h = addMsgHistory(h, fmt.Sprintf("msg %d", i), false, now)
+	}
+	if len(h) != maxMsgHistory || h[0].Message != fmt.Sprintf("msg %d", maxMsgHistory+9) {
+		t.Errorf("wrong history length %d or first entry %q", len(h), h[0].Message)
+	}
+}
+
+func TestMsgHistoryFile(t *testing.T) {
+	dir := testRepo(t)
+	defer os.RemoveAll(dir)
+
+	if h := loadMsgHistory(); len(h) != 0 {
+		t.Errorf("unexpected history %v", h)
+	}
+	h := addMsgHistory(nil, "subject\n\nbody\n", true, time.Unix(1000, 0))
+	if err := saveMsgHistory(h); err != nil {
+		t.Fatal(err)
+	}
+	h2 := loadMsgHistory()
+	if len(h2) != 1 || h2[0].Message != h[0].Message || !h2[0].When.Equal(h[0].When) || !h2[0].Committed {
+		t.Errorf("history mismatch %v %v", h, h2)
+	}
+}
+
+func TestCoAuthors(t *testing.T) {
+	dir := testRepo(t)
+	defer os.RemoveAll(dir)
+
+	testWrite(t, "a.txt", "two\n")
+	testGit(t, "-c", "user.name=Old Name", "-c", "user.email=old@example.com", "commit", "-q", "-a", "-m", "second")
+	testWrite(t, ".mailmap", "New Name <new@example.com> <old@example.com>\n")
+
+	idents, err := gitIdentities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(idents, ",") != "New Name <new@example.com>,test <test@example.com>" {
+		t.Errorf("wrong identities %q", idents)
+	}
+
+	msg, err := addCoAuthors("subject\n\nbody", idents[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err = addCoAuthors(msg, idents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tgt := "subject\n\nbody\n\nCo-authored-by: New Name <new@example.com>\nCo-authored-by: test <test@example.com>\n"
+	if msg != tgt {
+		t.Errorf("got %q expected %q", msg, tgt)
+	}
+}
@@ -1,25 +1,17 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"math/rand"
-	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"sync"
 
 	"github.com/aarzilli/nucular"
 )
 
-var rebaseServerMutex sync.Mutex
-
-func rebaseAction(lw *LogWindow, commitIdOrRef string) {
+func rebaseAction(lw *LogWindow, args ...string) {
 	/*if os.Getenv("EDITOR") == "E" {
 		cmd := exec.Command("git", "rebase", "-i", commitIdOrRef)
 		cmd.Dir = Repodir
@@ -31,105 +23,211 @@ func rebaseAction(lw *LogWindow, commitIdOrRef string) {
 		return
 	}*/
 
-	var socname string
-	var soc net.Listener
-	errcount := 0
-	for {
-		socname = "/tmp/fkgitseqpipe." + strconv.Itoa(rand.Int())
-		var err error
-		soc, err = net.Listen("unixpacket", socname)
-		if err == nil {
-			break
-		}
-		addrerr, ok := err.(*net.AddrError)
-		if !ok || !addrerr.Temporary() {
-			newMessagePopup(lw.mw, "Error", fmt.Sprintf("Could not bind socket for rebase: %v\n", err))
-			return
+	tab := newSequencerTab(lw.mw, rebaseOp)
+	tab.newcommand("git", append([]string{"rebase", "-i"}, args...)...)
+	openTab(tab)
+
+	go tab.runcommand()
+}
+
+type sequencerOp int
+
+const (
+	noSequencerOp sequencerOp = iota
+	rebaseOp
+	amOp
+	mergeOp
+	cherrypickOp
+	revertOp
+	bisectOp
+)
+
+func (op sequencerOp) String() string {
+	switch op {
+	case rebaseOp:
+		return "Rebase"
+	case amOp:
+		return "Apply"
+	case mergeOp:
+		return "Merge"
+	case cherrypickOp:
+		return "Cherry-pick"
+	case revertOp:
+		return "Revert"
+	case bisectOp:
+		return "Bisect"
+	}
+	return ""
+}
+
+type sequencerAction struct {
+	name     string
+	printcmd bool
+	args     []string
+}
+
+func (op sequencerOp) actions() []sequencerAction {
+	var cmd string
+	switch op {
+	case rebaseOp:
+		cmd = "rebase"
+	case amOp:
+		cmd = "am"
+	case mergeOp:
+		return []sequencerAction{
+			{"Abort", true, []string{"merge", "--abort"}},
+			{"Continue", true, []string{"merge", "--continue"}},
 		}
-		errcount++
-		if errcount > 5 {
-			newMessagePopup(lw.mw, "Error", fmt.Sprintf("Could not bind socket for rebase (repeated temporary errors): %v\n", err))
-			return
+	case cherrypickOp:
+		cmd = "cherry-pick"
+	case revertOp:
+		cmd = "revert"
+	case bisectOp:
+		return []sequencerAction{
+			{"Good", true, []string{"bisect", "good"}},
+			{"Bad", true, []string{"bisect", "bad"}},
+			{"Skip", true, []string{"bisect", "skip"}},
+			{"Reset", true, []string{"bisect", "reset"}},
 		}
+	default:
+		return nil
 	}
+	r := []sequencerAction{}
+	if op == rebaseOp {
+		r = append(r, sequencerAction{"Edit Todo", false, []string{"rebase", "--edit-todo"}})
+	}
+	return append(r,
+		sequencerAction{"Skip", true, []string{cmd, "--skip"}},
+		sequencerAction{"Abort", true, []string{cmd, "--abort"}},
+		sequencerAction{"Continue", true, []string{cmd, "--continue"}})
+}
 
-	os.Setenv("FKGIT_SEQUENCE_EDITOR_SOCKET", socname)
+// sequencerState returns the multi-step operation currently in progress in
+// the repository, if any.
+func sequencerState() sequencerOp {
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(Repodir, ".git", name))
+		return err == nil
+	}
+	switch {
+	case exists("rebase-merge"):
+		return rebaseOp
+	case exists("rebase-apply/applying"):
+		return amOp
+	case exists("rebase-apply"):
+		return rebaseOp
+	case exists("MERGE_HEAD"):
+		return mergeOp
+	case exists("CHERRY_PICK_HEAD"):
+		return cherrypickOp
+	case exists("REVERT_HEAD"):
+		return revertOp
+	case exists("BISECT_LOG"):
+		return bisectOp
+	}
+	return noSequencerOp
+}
 
-	tab := &rebaseTab{soc: soc, mw: lw.mw}
-	tab.newcommand("git", "rebase", "-i", commitIdOrRef)
+// sequencerStatus is the state of the repository shown in a new sequencer
+// tab.
+type sequencerStatus struct {
+	op        sequencerOp
+	status    string // output of git status
+	conflicts bool
+}
+
+func loadSequencerStatus() sequencerStatus {
+	ss := sequencerStatus{op: sequencerState()}
+	if ss.op != noSequencerOp {
+		ss.status, _ = execCommand("git", "--no-optional-locks", "status")
+		ss.conflicts = hasConflicts()
+	}
+	return ss
+}
+
+// checkSequencerState opens a sequencer tab for an operation in progress
+// that was started outside of fkgit (or before a restart) and marks as done
+// the sequencer tabs whose operation has been concluded elsewhere.
+// Must be called with the UI lock held, returns true if a tab was opened.
+func checkSequencerState(mw nucular.MasterWindow, ss sequencerStatus) bool {
+	op := ss.op
+	for _, tab := range tabs {
+		st, ok := tab.(*sequencerTab)
+		if !ok {
+			continue
+		}
+		st.mu.Lock()
+		if !st.done && st.cmd == nil && st.op != op {
+			st.done = true
+		}
+		running := !st.done
+		st.mu.Unlock()
+		if running {
+			return false
+		}
+	}
+	if op == noSequencerOp {
+		return false
+	}
+	tab := newSequencerTab(mw, op)
+	tab.ed.Buffer = []rune(fmt.Sprintf("%s in progress\n\n%s", op, ss.status))
+	tab.conflicts = ss.conflicts
 	openTab(tab)
+	return true
+}
 
-	go rebaseServer(soc, socname, tab)
-	go tab.runcommand()
+// checkSequencerStateAsync calls checkSequencerState after loading the
+// state of the repository in the background.
+func checkSequencerStateAsync(mw nucular.MasterWindow) {
+	go func() {
+		ss := loadSequencerStatus()
+		mw.Lock()
+		defer mw.Unlock()
+		checkSequencerState(mw, ss)
+		mw.Changed()
+	}()
+}
+
+func newSequencerTab(mw nucular.MasterWindow, op sequencerOp) *sequencerTab {
+	return &sequencerTab{op: op, mw: mw}
 }
 
-type rebaseTab struct {
-	soc net.Listener
-	mu  sync.Mutex
-	mw  nucular.MasterWindow
+type sequencerTab struct {
+	op sequencerOp
+	mu sync.Mutex
+	mw nucular.MasterWindow
 
 	ed nucular.TextEditor
 
-	// when in edit mode editing and editfile are set
-	// editing will be closed when the edit is done
-	// editfile must contain the file to edit
-	editing  chan struct{}
-	editfile string
-	editload bool
-
 	// when waiting for a background command to complete cmd is not nil
 	cmd *exec.Cmd
 
-	// when the rebase process is finished done is true
+	// when the operation is finished done is true
 	done bool
-}
 
-func rebaseServer(soc net.Listener, socname string, tab *rebaseTab) {
-	defer os.Remove(socname)
-	for {
-		conn, err := soc.Accept()
-		if err != nil {
-			return
-		}
-		b := bufio.NewReader(conn)
-		bs, err := b.ReadBytes('\x00')
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading rebase socket message: %v\n", err)
-			continue
-		}
-		typ := bs[0]
-		switch typ {
-		case 's':
-			tab.mu.Lock()
-			tab.editfile = string(bs[1 : len(bs)-1])
-			tab.editing = make(chan struct{})
-			tab.editload = true
-			tab.mu.Unlock()
-			<-tab.editing
-		case 'c':
-			idxmw.rebasedit = string(bs[1 : len(bs)-1])
-			idxmw.rebasechan = make(chan struct{})
-			idxmw.rebaseTab = tab
-			if i := tabIndex(&idxmw); i >= 0 {
-				currentTab = i
-			}
-			<-idxmw.rebasechan
-		}
-
-		conn.Write([]byte{'\x00'})
-		conn.Close()
-	}
+	// conflicts is true if the operation stopped because of merge conflicts
+	conflicts bool
 }
 
-func (rt *rebaseTab) Title() string {
-	return "Rebase"
+func (rt *sequencerTab) Title() string {
+	return rt.op.String()
 }
 
-func (rt *rebaseTab) Protected() bool {
+func (rt *sequencerTab) Protected() bool {
 	return !rt.done
 }
 
-func (rt *rebaseTab) Update(w *nucular.Window) {
+func (rt *sequencerTab) Update(w *nucular.Window) {
+	// the conflict tab locks the sequencer tabs, it can't be opened while
+	// rt.mu is held
+	if rt.update(w) {
+		newConflictTab(rt.mw)
+	}
+}
+
+// update draws the tab and returns true if the conflict tab should be
+// opened.
+func (rt *sequencerTab) update(w *nucular.Window) (resolve bool) {
 	rt.mu.Lock()
 	defer rt.mu.Unlock()
 
@@ -138,77 +236,45 @@ func (rt *rebaseTab) Update(w *nucular.Window) {
 	w.Row(0).Dynamic(1)
 
 	switch {
-	case rt.editing != nil: // editing mode
-		if rt.editload {
-			rt.editload = false
-			bs, err := ioutil.ReadFile(rt.editfile)
-			if err != nil {
-
-				close(rt.editing)
-				rt.editing = nil
-				rt.ed.Flags = nucular.EditSelectable | nucular.EditMultiline | nucular.EditFocusFollowsMouse | nucular.EditReadOnly | nucular.EditClipboard
-				rt.done = true
-				rt.ed.Buffer = rt.ed.Buffer[:0]
-				rt.ed.Buffer = append(rt.ed.Buffer, []rune(fmt.Sprintf("Error loading %s: %v\n", rt.editfile, err))...)
-				break
-			}
-			rt.ed.Buffer = []rune(string(bs))
-			rt.ed.Cursor = 0
-		}
-
-		rt.ed.Flags = nucular.EditSelectable | nucular.EditMultiline | nucular.EditFocusFollowsMouse | nucular.EditClipboard
-		rt.ed.Edit(w)
-		w.Row(25).Static(0, 100, 100)
-		w.Spacing(1)
-		if w.ButtonText("Ok") {
-			ioutil.WriteFile(rt.editfile, []byte(string(rt.ed.Buffer)), 0666)
-			rt.ed.Buffer = rt.ed.Buffer[:0]
-			close(rt.editing)
-			rt.editing = nil
-		}
-		if w.ButtonText("Cancel") {
-			if rt.cmd != nil {
-				rt.cmd.Process.Kill()
-			}
-			close(rt.editing)
-			rt.editing = nil
-		}
-
 	case rt.cmd != nil: // a command is being executed in background and we are waiting for it to finish
 		rt.ed.Flags = nucular.EditSelectable | nucular.EditMultiline | nucular.EditFocusFollowsMouse | nucular.EditClipboard | nucular.EditReadOnly
 		rt.ed.Edit(w)
 		w.Row(25).Static(0)
 		w.Spacing(1)
 
-	default: // rebase is temorarily stopped waiting for user to correct something
+	default: // operation is temorarily stopped waiting for user to correct something
 		rt.ed.Flags = nucular.EditSelectable | nucular.EditMultiline | nucular.EditFocusFollowsMouse | nucular.EditClipboard | nucular.EditReadOnly
 		rt.ed.Edit(w)
-		w.Row(25).Static(0, 100, 100, 100, 100)
+		actions := rt.op.actions()
+		widths := make([]int, len(actions)+1)
+		for i := 1; i < len(widths); i++ {
+			widths[i] = 100
+		}
+		if rt.conflicts {
+			widths = append(widths, 130)
+		}
+		w.Row(25).Static(widths...)
 
 		c := func(printcmd bool, cmd string, args ...string) {
 			rt.ed.Buffer = rt.ed.Buffer[:0]
 			if printcmd {
-				rt.ed.Buffer = append(rt.ed.Buffer, []rune("$ "+cmd+strings.Join(args, " ")+"\n")...)
+				rt.ed.Buffer = append(rt.ed.Buffer, []rune("$ "+cmd+" "+strings.Join(args, " ")+"\n")...)
 			}
 			rt.newcommand(cmd, args...)
 			go rt.runcommand()
 		}
 
 		w.Spacing(1)
-		if w.ButtonText("Edit Todo") {
-			c(false, "git", "rebase", "--edit-todo")
-		}
-		if w.ButtonText("Skip") {
-			c(true, "git", "rebase", "--skip")
-		}
-		if w.ButtonText("Abort") {
-			c(true, "git", "rebase", "--abort")
+		for _, action := range actions {
+			if w.ButtonText(action.name) {
+				c(action.printcmd, "git", action.args...)
+			}
 		}
-		if w.ButtonText("Continue") {
-			c(true, "git", "rebase", "--continue")
+		if rt.conflicts && w.ButtonText("Resolve conflicts") {
+			resolve = true
 		}
 
-	case rt.done: // rebase is finished
+	case rt.done: // operation is finished
 		rt.ed.Flags = nucular.EditSelectable | nucular.EditMultiline | nucular.EditFocusFollowsMouse | nucular.EditClipboard | nucular.EditReadOnly
 		rt.ed.Edit(w)
 		w.Row(25).Static(0, 100)
@@ -217,39 +283,16 @@ func (rt *rebaseTab) Update(w *nucular.Window) {
 			closeTab(rt)
 		}
 	}
+	return resolve
 }
 
-func (rt *rebaseTab) runcommand() {
-	stdout, _ := rt.cmd.StdoutPipe()
-	stderr, _ := rt.cmd.StderrPipe()
-	var wg sync.WaitGroup
-
-	filereader := func(fh io.ReadCloser) {
-		defer fh.Close()
-		defer wg.Done()
-		bsr := make([]byte, 4096)
-		for {
-			n, err := fh.Read(bsr)
-			if n > 0 {
-				rt.mu.Lock()
-				rt.ed.Buffer = append(rt.ed.Buffer, []rune(string(bsr[:n]))...)
-				rt.mw.Changed()
-				rt.mu.Unlock()
-			}
-			if err != nil {
-				break
-			}
-		}
-	}
-
-	err := rt.cmd.Start()
-	if err == nil {
-		wg.Add(2)
-		go filereader(stdout)
-		go filereader(stderr)
-		wg.Wait()
-		err = rt.cmd.Wait()
-	}
+func (rt *sequencerTab) runcommand() {
+	_, err := jobs.run(rt.cmd, nil, func(bs []byte) {
+		rt.mu.Lock()
+		rt.ed.Buffer = append(rt.ed.Buffer, []rune(string(bs))...)
+		rt.mw.Changed()
+		rt.mu.Unlock()
+	})
 
 	rt.mu.Lock()
 	defer rt.mu.Unlock()
@@ -258,30 +301,10 @@ func (rt *rebaseTab) runcommand() {
 	}
 	rt.cmd = nil
 
-	f1, _ := os.Stat(filepath.Join(Repodir, ".git", "rebase-merge"))
-	f2, _ := os.Stat(filepath.Join(Repodir, ".git", "rebase-apply"))
-	rt.done = f1 == nil && f2 == nil
-	if rt.done {
-		rt.soc.Close()
-	}
-}
-
-func (rt *rebaseTab) newcommand(cmd string, args ...string) {
-	rt.cmd = exec.Command(cmd, args...)
-	rt.cmd.Env = append(os.Environ(), "GIT_SEQUENCE_EDITOR=fkgit seqed", "GIT_EDITOR=fkgit comed")
-	rt.cmd.Dir = Repodir
+	rt.done = sequencerState() != rt.op
+	rt.conflicts = !rt.done && hasConflicts()
 }
 
-func editmodeMain() {
-	soc, err := net.Dial("unixpacket", os.Getenv("FKGIT_SEQUENCE_EDITOR_SOCKET"))
-	must(err)
-	switch os.Args[1] {
-	case "seqed":
-		soc.Write([]byte("s" + os.Args[2] + "\x00"))
-	case "comed":
-		soc.Write([]byte("c" + os.Args[2] + "\x00"))
-	}
-	bs := []byte{0}
-	soc.Read(bs)
-	soc.Close()
+func (rt *sequencerTab) newcommand(cmd string, args ...string) {
+	rt.cmd = editorCommand(cmd, args...)
 }
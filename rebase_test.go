@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// testGitConflict runs a git command that is expected to stop on a
+// conflict.
+func testGitConflict(t *testing.T, args ...string) {
+	cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = Repodir
+	if out, err := cmd.CombinedOutput(); err == nil {
+		t.Fatalf("git %v: expected a conflict\n%s", args, out)
+	}
+}
+
+func TestSequencerState(t *testing.T) {
+	dir := testRepo(t)
+	defer os.RemoveAll(dir)
+
+	testGit(t, "branch", "-M", "main")
+	testGit(t, "checkout", "-q", "-b", "other")
+	testWrite(t, "a.txt", "other\n")
+	testGit(t, "commit", "-q", "-a", "-m", "other")
+	testGit(t, "checkout", "-q", "main")
+	testWrite(t, "a.txt", "main\n")
+	testGit(t, "commit", "-q", "-a", "-m", "main")
+	testWrite(t, "a.txt", "third\n")
+	testGit(t, "commit", "-q", "-a", "-m", "third")
+	patch := filepath.Join(dir, ".git", "other.patch")
+	testWrite(t, filepath.Join(".git", "other.patch"), testGit(t, "format-patch", "-1", "--stdout", "other"))
+
+	if ss := loadSequencerStatus(); ss.op != noSequencerOp || ss.conflicts {
+		t.Errorf("unexpected operation %#v", ss)
+	}
+
+	for _, tc := range []struct {
+		args     []string
+		conflict bool
+		op       sequencerOp
+		abort    []string
+	}{
+		{[]string{"merge", "other"}, true, mergeOp, []string{"merge", "--abort"}},
+		{[]string{"cherry-pick", "other"}, true, cherrypickOp, []string{"cherry-pick", "--abort"}},
+		{[]string{"revert", "--no-edit", "HEAD~1"}, true, revertOp, []string{"revert", "--abort"}},
+		{[]string{"rebase", "other"}, true, rebaseOp, []string{"rebase", "--abort"}},
+		{[]string{"am", patch}, true, amOp, []string{"am", "--abort"}},
+		{[]string{"bisect", "start", "HEAD", "HEAD~2"}, false, bisectOp, []string{"bisect", "reset"}},
+	} {
+		if tc.conflict {
+			testGitConflict(t, tc.args...)
+		} else {
+			testGit(t, tc.args...)
+		}
+		// git am without -3 stops without leaving conflicted files
+		ss := loadSequencerStatus()
+		if ss.op != tc.op || ss.conflicts != (tc.conflict && tc.op != amOp) || ss.status == "" {
+			t.Errorf("%v: got %#v expected %v", tc.args, ss, tc.op)
+		}
+		testGit(t, tc.abort...)
+		if op := sequencerState(); op != noSequencerOp {
+			t.Errorf("%v: operation still in progress after abort: %v", tc.args, op)
+		}
+	}
+}
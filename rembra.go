@@ -1,25 +1,29 @@
 package main
 
 import (
+	"fmt"
 	"image"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/aarzilli/nucular"
 	"github.com/aarzilli/nucular/label"
 )
 
 type remotesTab struct {
+	mw          nucular.MasterWindow
 	ed          nucular.TextEditor
-	remotes     map[string]string
+	remotes     map[string]Remote
 	remoteNames []string
+	branches    map[string]int
+	fetched     map[string]time.Time
 }
 
-func newRemotesTab() {
-	rt := &remotesTab{}
+func newRemotesTab(mw nucular.MasterWindow) {
+	rt := &remotesTab{mw: mw}
 	rt.ed.Flags = nucular.EditSelectable | nucular.EditClipboard
 	rt.loadRemotes()
-	sort.Strings(rt.remoteNames)
 	openTab(rt)
 }
 
@@ -29,6 +33,8 @@ func (rt *remotesTab) loadRemotes() {
 	for k := range rt.remotes {
 		rt.remoteNames = append(rt.remoteNames, k)
 	}
+	sort.Strings(rt.remoteNames)
+	rt.branches, rt.fetched = remoteStats(rt.remotes)
 }
 
 func (rt *remotesTab) Title() string {
@@ -40,43 +46,76 @@ func (rt *remotesTab) Protected() bool {
 }
 
 func (rt *remotesTab) Update(w *nucular.Window) {
-	w.Row(25).Static(90, 0)
+	w.Row(25).Static(90, 0, 100)
 	w.Label("Filter:", "LC")
 	rt.ed.Edit(w)
+	if w.ButtonText("Add...") {
+		newRemotePopup(rt, nil)
+	}
 
 	needle := string(rt.ed.Buffer)
 	w.Row(0).Dynamic(1)
 	if w := w.GroupBegin("remotes", nucular.WindowNoHScrollbar); w != nil {
-		w.Row(20).Static(180, 0, 100)
 		for _, name := range rt.remoteNames {
 			if strings.Index(name, needle) < 0 {
 				continue
 			}
+			name := name
+			remote := rt.remotes[name]
+			w.Row(20).Static(180, 0, 60, 60, 60, 70, 70)
 			w.Label(name, "LC")
-			w.Label(rt.remotes[name], "LC")
+			w.Label(remote.FetchURL, "LC")
+			if w.ButtonText("Fetch") {
+				execBackground(false, &lw, "git", "fetch", name)
+			}
+			if w.ButtonText("Prune") {
+				execBackground(false, &lw, "git", "remote", "prune", name)
+			}
+			if w.ButtonText("Edit...") {
+				newRemotePopup(rt, &remote)
+			}
+			if w.ButtonText("Rename...") {
+				newInputPopup(rt.mw, "Rename remote...", "New name:", name, func(newname string) {
+					rt.run(func() (string, error) {
+						return runJob(editorCommand("git", "remote", "rename", name, newname))
+					})
+				})
+			}
 			if w.ButtonText("Delete") {
-				execBackground(true, &lw, "git", "remote", "remove", name)
-				go func() {
-					lw.mu.Lock()
-					lw.reload()
-					lw.mu.Unlock()
-				}()
-				rt.loadRemotes()
+				newConfirmPopup(rt.mw, "Delete remote...", fmt.Sprintf("Delete remote %s and its remote-tracking branches?", name), "Delete", func() {
+					rt.run(func() (string, error) {
+						return runJob(editorCommand("git", "remote", "remove", name))
+					})
+				})
+			}
+			w.Row(20).Static(180, 0)
+			w.Spacing(1)
+			if remote.PushURL != remote.FetchURL {
+				w.Label("push: "+remote.PushURL, "LC")
+				w.Spacing(1)
 			}
+			w.Label(fmt.Sprintf("%d branches, last fetched %s", rt.branches[name], formatFetchTime(rt.fetched[name])), "LC")
 		}
 		w.GroupEnd()
 	}
 }
 
 type refsTab struct {
+	mw          nucular.MasterWindow
 	ed          nucular.TextEditor
 	refs        []Ref
 	commits     map[string]Commit
+	tags        map[string]tagInfo
+	branches    map[string]branchInfo
+	merged      map[string]bool
+	remotes     []string
 	selectedRef Ref
+	loading     bool
+	gen         int
 }
 
-func newRefsTab() {
-	rt := &refsTab{}
+func newRefsTab(mw nucular.MasterWindow) {
+	rt := &refsTab{mw: mw}
 	rt.ed.Flags = nucular.EditSelectable | nucular.EditClipboard
 	rt.loadRefs()
 	openTab(rt)
@@ -103,27 +142,40 @@ func (v refsByCommitDate) Less(i, j int) bool {
 	}
 }
 
+// loadRefs loads the references in the background, must be called with the
+// UI lock held.
 func (rt *refsTab) loadRefs() {
-	rt.refs, _ = allRefs()
-	if rt.commits == nil {
-		rt.commits = map[string]Commit{}
+	rt.gen++
+	gen := rt.gen
+	rt.loading = true
+	commits := make(map[string]Commit, len(rt.commits))
+	for id, commit := range rt.commits {
+		commits[id] = commit
 	}
-
-	for _, ref := range rt.refs {
-		if _, ok := rt.commits[ref.CommitId]; !ok {
-			rt.commits[ref.CommitId] = Commit{}
+	go func() {
+		refs, _ := allRefs()
+		tags, _ := tagInfos()
+		branches, _ := branchInfos()
+		merged, _ := mergedRefs()
+		remotes := remoteNames()
+		for _, ref := range refs {
+			if commits[ref.CommitId].Id == "" {
+				commits[ref.CommitId], _ = LoadCommit(ref.CommitId)
+			}
 		}
-	}
-
-	rt.selectedRef = Ref{}
+		sort.Sort(refsByCommitDate{refs, commits})
 
-	for commitId := range rt.commits {
-		if rt.commits[commitId].Id != "" {
-			continue
+		rt.mw.Lock()
+		defer rt.mw.Unlock()
+		if gen != rt.gen {
+			return
 		}
-		rt.commits[commitId], _ = LoadCommit(commitId)
-	}
-	sort.Sort(refsByCommitDate{rt.refs, rt.commits})
+		rt.loading = false
+		rt.refs, rt.tags, rt.branches, rt.merged, rt.remotes = refs, tags, branches, merged, remotes
+		rt.commits = commits
+		rt.selectedRef = Ref{}
+		rt.mw.Changed()
+	}()
 }
 
 func (rt *refsTab) Title() string {
@@ -135,9 +187,12 @@ func (rt *refsTab) Protected() bool {
 }
 
 func (rt *refsTab) Update(w *nucular.Window) {
-	w.Row(25).Static(90, 0)
+	w.Row(25).Static(90, 0, 150)
 	w.Label("Filter:", "LC")
 	rt.ed.Edit(w)
+	if w.ButtonText("Delete merged...") {
+		newDeleteMergedPopup(rt.mw, rt.refs, rt.merged)
+	}
 
 	needle := string(rt.ed.Buffer)
 
@@ -145,10 +200,23 @@ func (rt *refsTab) Update(w *nucular.Window) {
 
 	datesz := nucular.FontWidth(style.Font, "0000-00-00 00:000") + style.Text.Padding.X*2
 	idsz := nucular.FontWidth(style.Font, "0000000") + style.Text.Padding.X*2
+	tracksz := nucular.FontWidth(style.Font, "+0000 -0000") + style.Text.Padding.X*2
+	mergedsz := nucular.FontWidth(style.Font, "not merged") + style.Text.Padding.X*2
 
-	w.Row(0).Dynamic(1)
+	info, hasInfo := rt.tags[rt.selectedRef.nice]
+	hasInfo = hasInfo && rt.selectedRef.Kind == TagRef
+	if hasInfo {
+		w.RowScaled(w.LayoutAvailableHeight() - int(150*style.Scaling)).Dynamic(1)
+	} else {
+		w.Row(0).Dynamic(1)
+	}
 	if w := w.GroupBegin("remotes", nucular.WindowNoHScrollbar); w != nil {
-		w.Row(20).StaticScaled(idsz, 0, datesz)
+		gw := w
+		if rt.loading && len(rt.refs) == 0 {
+			w.Row(20).Dynamic(1)
+			w.Label("Loading...", "LC")
+		}
+		w.Row(20).StaticScaled(idsz, 0, tracksz, mergedsz, datesz)
 		update := false
 		for i := range rt.refs {
 			ref := &rt.refs[i]
@@ -156,6 +224,11 @@ func (rt *refsTab) Update(w *nucular.Window) {
 			if strings.Index(name, needle) < 0 {
 				continue
 			}
+			if ref.Kind == TagRef {
+				if info, ok := rt.tags[ref.nice]; ok {
+					name += "  " + strings.SplitN(info.Message, "\n", 2)[0]
+				}
+			}
 			commit := rt.commits[ref.CommitId]
 			selected := rt.selectedRef == *ref
 			rowwidth := w.LayoutAvailableWidth()
@@ -163,6 +236,19 @@ func (rt *refsTab) Update(w *nucular.Window) {
 			rowbounds := w.LastWidgetBounds
 			rowbounds.W = rowwidth
 			w.SelectableLabel(name, "LC", &selected)
+			track, merged := "", ""
+			if ref.Kind == LocalRef {
+				bi := rt.branches[ref.nice]
+				track = bi.Track()
+			}
+			if ref.Kind != TagRef {
+				merged = "not merged"
+				if rt.merged[ref.Name] {
+					merged = "merged"
+				}
+			}
+			w.SelectableLabel(track, "RC", &selected)
+			w.SelectableLabel(merged, "LC", &selected)
 			w.SelectableLabel(commit.CommitterDate.Local().Format("2006-01-02 15:04"), "RC", &selected)
 			if selected {
 				rt.selectedRef = *ref
@@ -177,16 +263,19 @@ func (rt *refsTab) Update(w *nucular.Window) {
 						update = true
 					}
 				}
-				if ref.Kind == RemoteRef {
+				switch ref.Kind {
+				case RemoteRef:
 					if w.MenuItem(label.TA("remove remote", "LC")) {
-						execBackground(true, &lw, "git", "branch", "-rD", rt.selectedRef.nice)
-						execBackground(true, &lw, "git", "push", rt.selectedRef.Remote(), "--delete", rt.selectedRef.nice[len(rt.selectedRef.Remote())+1:])
-						update = true
+						rt.deleteRemote(rt.selectedRef)
 					}
-				} else {
+				case TagRef:
+					rt.tagMenu(w)
+				default:
+					rt.branchMenu(w, gw)
 					if w.MenuItem(label.TA("remove", "LC")) {
-						execBackground(true, &lw, "git", "branch", "-D", rt.selectedRef.nice)
-						update = true
+						// not waited for, the job could be queued behind a
+						// slow one
+						rt.run("git", "branch", "-D", rt.selectedRef.nice)
 					}
 				}
 			}
@@ -201,4 +290,112 @@ func (rt *refsTab) Update(w *nucular.Window) {
 		}
 		w.GroupEnd()
 	}
+
+	if hasInfo {
+		w.Row(20).Dynamic(1)
+		w.Label(fmt.Sprintf("Tagger: %s on %s", info.Tagger, info.When.Local().Format("2006-01-02 15:04")), "LC")
+		w.Row(0).Dynamic(1)
+		if w := w.GroupBegin("tag-message", nucular.WindowBorder); w != nil {
+			w.Row(20).Dynamic(1)
+			showLines(w, info.Message)
+			w.GroupEnd()
+		}
+	}
+}
+
+// tagMenu adds to the contextual menu the actions on the selected tag.
+func (rt *refsTab) tagMenu(w *nucular.Window) {
+	tag := rt.selectedRef.nice
+	for _, remote := range rt.remotes {
+		if w.MenuItem(label.TA(fmt.Sprintf("push to %s", remote), "LC")) {
+			execBackground(false, &lw, "git", "push", remote, "refs/tags/"+tag)
+		}
+	}
+	if w.MenuItem(label.TA("remove", "LC")) {
+		rt.run("git", "tag", "-d", tag)
+	}
+	for _, remote := range rt.remotes {
+		remote := remote
+		if w.MenuItem(label.TA(fmt.Sprintf("remove from %s", remote), "LC")) {
+			newConfirmPopup(rt.mw, "Remove tag...", fmt.Sprintf("Remove tag %s from %s?", tag, remote), "Remove", func() {
+				rt.run("git", "push", remote, "--delete", "refs/tags/"+tag)
+			})
+		}
+	}
+}
+
+// branchMenu adds to the contextual menu the actions on the selected local
+// branch, gw is the window used to pick the upstream.
+func (rt *refsTab) branchMenu(w, gw *nucular.Window) {
+	branch := rt.selectedRef.nice
+	if w.MenuItem(label.TA("rename...", "LC")) {
+		newInputPopup(rt.mw, "Rename branch...", "New name:", branch, func(name string) {
+			rt.run("git", "branch", "-m", branch, name)
+		})
+	}
+	if w.MenuItem(label.TA("set upstream...", "LC")) {
+		upstreams := []string{}
+		for _, ref := range rt.refs {
+			if ref.Kind == RemoteRef && !strings.HasSuffix(ref.nice, "/HEAD") {
+				upstreams = append(upstreams, ref.nice)
+			}
+		}
+		sort.Strings(upstreams)
+		selectFromListWindow(gw, "Set upstream...", fmt.Sprintf("Pick the upstream of %s:", branch), upstreams, func(idx int) {
+			if idx >= 0 {
+				rt.run("git", "branch", "--set-upstream-to="+upstreams[idx], branch)
+			}
+		})
+	}
+	if bi := rt.branches[branch]; bi.Upstream != "" {
+		if w.MenuItem(label.TA(fmt.Sprintf("unset upstream (%s)", bi.Upstream), "LC")) {
+			rt.run("git", "branch", "--unset-upstream", branch)
+		}
+	}
+}
+
+func (rt *refsTab) deleteRemote(ref Ref) {
+	remote, branch := ref.Remote(), ref.RemoteName()
+	newConfirmPopup(rt.mw, "Remove remote branch...", fmt.Sprintf("Remove %s from %s?", branch, remote), "Remove", func() {
+		go func() {
+			out, err := deleteRemoteBranch(remote, branch)
+			if err != nil {
+				newMessagePopup(rt.mw, "Error", fmt.Sprintf("Error: %v\n%s\n", err, out))
+			}
+			refreshAfterChange(rt.mw, changeRefs)
+		}()
+	})
+}
+
+// run runs a git command in the background, showing its output in the
+// graph tab.
+func (rt *refsTab) run(cmdname string, args ...string) {
+	go func() {
+		execBackground(true, &lw, cmdname, args...)
+		refreshAfterChange(rt.mw, changeRefs)
+	}()
+}
+
+// reloadRemotesTab reloads the remotes tab, if it is open.
+func reloadRemotesTab(mw nucular.MasterWindow) {
+	mw.Lock()
+	defer mw.Unlock()
+	for _, tab := range tabs {
+		if rt, ok := tab.(*remotesTab); ok {
+			rt.loadRemotes()
+		}
+	}
+	mw.Changed()
+}
+
+// reloadRefsTab reloads the refs tab, if it is open.
+func reloadRefsTab(mw nucular.MasterWindow) {
+	mw.Lock()
+	defer mw.Unlock()
+	for _, tab := range tabs {
+		if rt, ok := tab.(*refsTab); ok {
+			rt.loadRefs()
+		}
+	}
+	mw.Changed()
 }
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aarzilli/nucular"
+	"github.com/aarzilli/nucular/rect"
+)
+
+// remoteStats returns, for each remote, the number of remote-tracking
+// branches and the time of the last fetch.
+func remoteStats(remotes map[string]Remote) (branches map[string]int, fetched map[string]time.Time) {
+	branches = map[string]int{}
+	fetched = map[string]time.Time{}
+
+	out, _ := execCommand("git", "for-each-ref", "--format=%(refname:strip=2)", "refs/remotes")
+	for _, name := range strings.Split(out, "\n") {
+		if name == "" || strings.HasSuffix(name, "/HEAD") {
+			continue
+		}
+		if remote := remoteOf(name, remotes); remote != "" {
+			branches[remote]++
+		}
+	}
+
+	// the reflogs of the remote-tracking branches are updated by fetches
+	// that change something, FETCH_HEAD is rewritten by every fetch but
+	// only records the last fetched remote
+	for name := range remotes {
+		filepath.Walk(filepath.Join(Repodir, ".git", "logs", "refs", "remotes", name), func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode().IsRegular() && info.ModTime().After(fetched[name]) {
+				fetched[name] = info.ModTime()
+			}
+			return nil
+		})
+	}
+	fetchHead := filepath.Join(Repodir, ".git", "FETCH_HEAD")
+	if info, err := os.Stat(fetchHead); err == nil {
+		bs, _ := ioutil.ReadFile(fetchHead)
+		for name, remote := range remotes {
+			// git strips trailing slashes and .git from the URL
+			url := strings.TrimSuffix(strings.TrimRight(remote.FetchURL, "/"), ".git")
+			if url != "" && strings.Contains(string(bs), " of "+url+"\n") && info.ModTime().After(fetched[name]) {
+				fetched[name] = info.ModTime()
+			}
+		}
+	}
+	return branches, fetched
+}
+
+// remoteOf returns the remote of a remote-tracking branch name, remote names
+// can contain '/' so the longest matching remote is returned.
+func remoteOf(name string, remotes map[string]Remote) string {
+	r := ""
+	for remote := range remotes {
+		if strings.HasPrefix(name, remote+"/") && len(remote) > len(r) {
+			r = remote
+		}
+	}
+	return r
+}
+
+// setRemoteURLs changes the fetch and push URLs of a remote, an empty push
+// URL means that the fetch URL is also used for pushing.
+func setRemoteURLs(old Remote, fetchURL, pushURL string) (string, error) {
+	if fetchURL != old.FetchURL {
+		if out, err := runJob(editorCommand("git", "remote", "set-url", old.Name, fetchURL)); err != nil {
+			return out, err
+		}
+	}
+	if pushURL == "" || pushURL == fetchURL {
+		if _, err := execCommand("git", "config", "--get", "remote."+old.Name+".pushurl"); err != nil {
+			return "", nil
+		}
+		return runJob(editorCommand("git", "config", "--unset-all", "remote."+old.Name+".pushurl"))
+	}
+	if pushURL != old.PushURL {
+		return runJob(editorCommand("git", "remote", "set-url", "--push", old.Name, pushURL))
+	}
+	return "", nil
+}
+
+// remotePopup adds a new remote or edits the URLs of an existing one.
+type remotePopup struct {
+	rt       *remotesTab
+	remote   *Remote // nil when adding a remote
+	name     nucular.TextEditor
+	fetchURL nucular.TextEditor
+	pushURL  nucular.TextEditor
+	fetch    bool
+}
+
+func newRemotePopup(rt *remotesTab, remote *Remote) {
+	rp := &remotePopup{rt: rt, remote: remote, fetch: true}
+	for _, ed := range []*nucular.TextEditor{&rp.name, &rp.fetchURL, &rp.pushURL} {
+		ed.Flags = nucular.EditSelectable | nucular.EditClipboard
+	}
+	title := "Add remote..."
+	if remote != nil {
+		title = "Edit remote..."
+		rp.fetchURL.Buffer = []rune(remote.FetchURL)
+		if remote.PushURL != remote.FetchURL {
+			rp.pushURL.Buffer = []rune(remote.PushURL)
+		}
+		rp.fetchURL.Active = true
+	} else {
+		rp.name.Active = true
+	}
+	rt.mw.PopupOpen(title, popupFlags, rect.Rect{20, 100, 560, 250}, true, rp.Update)
+}
+
+func (rp *remotePopup) Update(w *nucular.Window) {
+	w.Row(25).Static(100, 0)
+	w.Label("Name:", "LC")
+	if rp.remote != nil {
+		w.Label(rp.remote.Name, "LC")
+	} else {
+		rp.name.Edit(w)
+	}
+	w.Label("Fetch URL:", "LC")
+	rp.fetchURL.Edit(w)
+	w.Label("Push URL:", "LC")
+	rp.pushURL.Edit(w)
+	w.Row(25).Dynamic(1)
+	if rp.remote == nil {
+		w.CheckboxText("Fetch after adding", &rp.fetch)
+	} else {
+		w.Label("Leave the push URL empty to push to the fetch URL.", "LC")
+	}
+
+	name := strings.TrimSpace(string(rp.name.Buffer))
+	fetchURL := strings.TrimSpace(string(rp.fetchURL.Buffer))
+	pushURL := strings.TrimSpace(string(rp.pushURL.Buffer))
+	oktext := "OK"
+	if fetchURL == "" || (rp.remote == nil && name == "") {
+		oktext = ""
+	}
+	ok, _ := okCancelButtons(w, false, oktext, true)
+	if !ok {
+		return
+	}
+	if rp.remote != nil {
+		old := *rp.remote
+		rp.rt.run(func() (string, error) {
+			return setRemoteURLs(old, fetchURL, pushURL)
+		})
+		return
+	}
+	fetch := rp.fetch
+	rp.rt.run(func() (string, error) {
+		out, err := runJob(editorCommand("git", "remote", "add", name, fetchURL))
+		if err != nil {
+			return out, err
+		}
+		if pushURL != "" && pushURL != fetchURL {
+			if out, err := runJob(editorCommand("git", "remote", "set-url", "--push", name, pushURL)); err != nil {
+				return out, err
+			}
+		}
+		if fetch {
+			// shown in the output of the log window, credentials can be
+			// asked and the fetch cancelled
+			execBackground(false, &lw, "git", "fetch", name)
+		}
+		return out, nil
+	})
+}
+
+func formatFetchTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+// run runs fn in the background and reloads the remotes.
+func (rt *remotesTab) run(fn func() (string, error)) {
+	go func() {
+		out, err := fn()
+		if err != nil {
+			newMessagePopup(rt.mw, "Error", fmt.Sprintf("Error: %v\n%s\n", err, out))
+		}
+		// changes to the configuration are not seen by the watcher
+		refreshAfterChange(rt.mw, changeRefs)
+	}()
+}
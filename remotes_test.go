@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestParseRemotes(t *testing.T) {
+	remotes := parseRemotes("origin\thttps://example.com/a.git (fetch)\norigin\tgit@example.com:a.git (push)\nother\t/tmp/other (fetch)\nother\t/tmp/other (push)\n")
+	if len(remotes) != 2 {
+		t.Fatalf("wrong remotes %#v", remotes)
+	}
+	if r := remotes["origin"]; r.Name != "origin" || r.FetchURL != "https://example.com/a.git" || r.PushURL != "git@example.com:a.git" {
+		t.Errorf("wrong origin %#v", r)
+	}
+	if r := remotes["other"]; r.FetchURL != "/tmp/other" || r.PushURL != "/tmp/other" {
+		t.Errorf("wrong other %#v", r)
+	}
+}
+
+func TestRemotes(t *testing.T) {
+	dir := testRepo(t)
+	defer os.RemoveAll(dir)
+	remote, err := ioutil.TempDir("", "fkgit-remote")
+	must(err)
+	defer os.RemoveAll(remote)
+	testGit(t, "init", "-q", "--bare", remote)
+	testGit(t, "push", "-q", remote, "HEAD:refs/heads/a", "HEAD:refs/heads/b")
+	testGit(t, "remote", "add", "origin", remote)
+	testGit(t, "remote", "add", "origin/nested", remote)
+
+	remotes := allRemotes()
+	branches, fetched := remoteStats(remotes)
+	if branches["origin"] != 0 || !fetched["origin"].IsZero() {
+		t.Errorf("wrong stats before fetch %v %v", branches, fetched)
+	}
+
+	testGit(t, "fetch", "-q", "origin")
+	testGit(t, "fetch", "-q", "origin/nested")
+	branches, fetched = remoteStats(remotes)
+	if branches["origin"] != 2 || branches["origin/nested"] != 2 || fetched["origin"].IsZero() || fetched["origin/nested"].IsZero() {
+		t.Errorf("wrong stats after fetch %v %v", branches, fetched)
+	}
+
+	if out, err := setRemoteURLs(remotes["origin"], remote, "/tmp/push"); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if r := allRemotes()["origin"]; r.FetchURL != remote || r.PushURL != "/tmp/push" {
+		t.Errorf("wrong urls %#v", r)
+	}
+	if out, err := setRemoteURLs(allRemotes()["origin"], "/tmp/fetch", ""); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if r := allRemotes()["origin"]; r.FetchURL != "/tmp/fetch" || r.PushURL != "/tmp/fetch" {
+		t.Errorf("wrong urls after unsetting the push URL %#v", r)
+	}
+}
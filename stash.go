@@ -0,0 +1,411 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aarzilli/nucular"
+	"github.com/aarzilli/nucular/rect"
+)
+
+type stashEntry struct {
+	Ref     string // stash@{N}
+	Id      string
+	Base    string // commit the stash was created on
+	When    time.Time
+	Message string
+}
+
+func stashList() ([]stashEntry, error) {
+	out, err := execCommand("git", "stash", "list", "--format=%gd%x00%H%x00%P%x00%ct%x00%gs")
+	if err != nil {
+		return nil, fmt.Errorf("git stash list: %v\n%s", err, out)
+	}
+	return parseStashList(out), nil
+}
+
+func parseStashList(out string) []stashEntry {
+	r := []stashEntry{}
+	for _, line := range strings.Split(out, "\n") {
+		v := strings.SplitN(line, "\x00", 5)
+		if len(v) != 5 {
+			continue
+		}
+		e := stashEntry{Ref: v[0], Id: v[1], Message: v[4]}
+		if parents := strings.Fields(v[2]); len(parents) > 0 {
+			e.Base = parents[0]
+		}
+		if ts, err := strconv.ParseInt(v[3], 10, 64); err == nil {
+			e.When = time.Unix(ts, 0)
+		}
+		r = append(r, e)
+	}
+	return r
+}
+
+// stashDiff returns the changes saved in a stash, including untracked
+// files if git supports it.
+func stashDiff(ref string) Diff {
+	out, err := execCommand("git", "stash", "show", "-p", "--color=never", "--include-untracked", ref)
+	if err != nil {
+		out, err = execCommand("git", "stash", "show", "-p", "--color=never", ref)
+	}
+	if err != nil {
+		return errorDiff(ref, out, err)
+	}
+	diff := parseDiff([]byte(out))
+	describeBinary(diff)
+	return diff
+}
+
+// checkStash returns an error if e.Ref no longer refers to the stash e,
+// which happens when stashes are created or dropped after the list was
+// loaded.
+func checkStash(e *stashEntry) error {
+	return checkStashRef(e.Ref, e.Id)
+}
+
+func checkStashRef(ref, id string) error {
+	out, err := execCommand("git", "rev-parse", "-q", "--verify", ref)
+	if err != nil || strings.TrimSpace(out) != id {
+		return fmt.Errorf("%s changed since the list of stashes was loaded", ref)
+	}
+	return nil
+}
+
+// renameStash changes the message of a stash, the renamed stash becomes
+// stash@{0}. The old entry is dropped only after the new one was stored.
+func renameStash(e *stashEntry, msg string) (string, error) {
+	var n int
+	if _, err := fmt.Sscanf(e.Ref, "stash@{%d}", &n); err != nil {
+		return "", fmt.Errorf("unknown stash %s", e.Ref)
+	}
+	if err := checkStash(e); err != nil {
+		return "", err
+	}
+	out, err := runJob(editorCommand("git", "stash", "store", "-m", msg, e.Id))
+	if err != nil {
+		return out, err
+	}
+	old := fmt.Sprintf("stash@{%d}", n+1)
+	if err := checkStashRef(old, e.Id); err != nil {
+		return out, err
+	}
+	return runJob(editorCommand("git", "stash", "drop", "-q", old))
+}
+
+type stashMode int
+
+const (
+	stashAll stashMode = iota
+	stashStaged
+	stashFiles
+)
+
+// stashArgs returns the arguments for git stash push, paths is only used
+// by stashFiles.
+func stashArgs(mode stashMode, msg string, untracked bool, paths []string) []string {
+	args := []string{"stash", "push"}
+	if msg != "" {
+		args = append(args, "-m", msg)
+	}
+	switch mode {
+	case stashStaged:
+		return append(args, "--staged")
+	case stashFiles:
+		if untracked {
+			args = append(args, "--include-untracked")
+		}
+		return append(append(args, "--"), paths...)
+	}
+	if untracked {
+		args = append(args, "--include-untracked")
+	}
+	return args
+}
+
+// stashApplyArgs returns the arguments to apply or pop (op) the stash ref,
+// if index is set the staged changes are restored too.
+func stashApplyArgs(op, ref string, index bool) []string {
+	args := []string{"stash", op}
+	if index {
+		args = append(args, "--index")
+	}
+	return append(args, ref)
+}
+
+type stashTab struct {
+	mw       nucular.MasterWindow
+	stashes  []stashEntry
+	err      error
+	selected int
+	diff     Diff
+	loading  bool
+	index    bool // Apply and Pop restore the index
+	split    nucular.ScalableSplit
+}
+
+func newStashTab(mw nucular.MasterWindow) {
+	for _, tab := range tabs {
+		if st, ok := tab.(*stashTab); ok {
+			st.reload()
+			currentTab = tabIndex(st)
+			return
+		}
+	}
+	st := &stashTab{mw: mw, selected: -1, index: true}
+	st.split.MinSize = 80
+	st.split.Size = 200
+	st.split.Spacing = 5
+	st.reload()
+	openTab(st)
+}
+
+// reloadStashTab reloads the stash tab, if it is open.
+func reloadStashTab(mw nucular.MasterWindow) {
+	mw.Lock()
+	defer mw.Unlock()
+	for _, tab := range tabs {
+		if st, ok := tab.(*stashTab); ok {
+			st.reload()
+		}
+	}
+	mw.Changed()
+}
+
+// reload loads the list of stashes in the background, must be called with
+// the UI lock held.
+func (st *stashTab) reload() {
+	st.loading = true
+	go func() {
+		stashes, err := stashList()
+		st.mw.Lock()
+		defer st.mw.Unlock()
+		st.loading = false
+		st.stashes, st.err = stashes, err
+		st.selected = -1
+		st.diff = nil
+		st.mw.Changed()
+	}()
+}
+
+func (st *stashTab) Title() string {
+	return "Stashes"
+}
+
+func (st *stashTab) Protected() bool {
+	return false
+}
+
+func (st *stashTab) Update(w *nucular.Window) {
+	w.Row(25).Static(0, 120, 80, 80, 80, 100, 100, 80)
+	w.Spacing(1)
+	if st.selected >= 0 {
+		e := st.stashes[st.selected]
+		w.CheckboxText("Restore index", &st.index)
+		if w.ButtonText("Apply") {
+			st.runStash(e, "git", stashApplyArgs("apply", e.Ref, st.index)...)
+		}
+		if w.ButtonText("Pop") {
+			st.runStash(e, "git", stashApplyArgs("pop", e.Ref, st.index)...)
+		}
+		if w.ButtonText("Drop") {
+			newStashDropPopup(st, e)
+		}
+		if w.ButtonText("Branch...") {
+			newInputPopup(st.mw, "Branch from stash...", "Name of the new branch:", "", func(name string) {
+				st.runStash(e, "git", "stash", "branch", name, e.Ref)
+			})
+		}
+		if w.ButtonText("Rename...") {
+			newInputPopup(st.mw, "Rename stash...", "Message:", e.Message, func(msg string) {
+				go func() {
+					if out, err := renameStash(&e, msg); err != nil {
+						newMessagePopup(st.mw, "Error", fmt.Sprintf("Error: %v\n%s\n", err, out))
+					}
+					refreshAfterChange(st.mw, changeAll)
+				}()
+			})
+		}
+	} else {
+		w.Spacing(6)
+	}
+	if w.ButtonText("Reload") {
+		st.reload()
+	}
+
+	area := w.Row(0).SpaceBegin(0)
+	listbounds, diffbounds := st.split.Horizontal(w, area)
+
+	w.LayoutSpacePushScaled(listbounds)
+	if sw := w.GroupBegin("stash-list", nucular.WindowBorder|nucular.WindowNoHScrollbar); sw != nil {
+		sw.Row(20).Static(180, 100, 0)
+		switch {
+		case st.err != nil:
+			sw.Row(20).Dynamic(1)
+			sw.Label(strings.SplitN(st.err.Error(), "\n", 2)[0], "LC")
+		case st.loading:
+			sw.Row(20).Dynamic(1)
+			sw.Label("Loading...", "LC")
+		case len(st.stashes) == 0:
+			sw.Row(20).Dynamic(1)
+			sw.Label("No stashes", "LC")
+		}
+		for i, e := range st.stashes {
+			selected := st.selected == i
+			sw.SelectableLabel(e.When.Format("2006-01-02 15:04:05"), "LC", &selected)
+			sw.SelectableLabel(abbrev(e.Base), "LC", &selected)
+			sw.SelectableLabel(e.Message, "LC", &selected)
+			if selected && st.selected != i {
+				st.selected = i
+				st.diff = nil
+				sel, ref := i, e.Ref
+				go func() {
+					diff := stashDiff(ref)
+					st.mw.Lock()
+					defer st.mw.Unlock()
+					if st.selected == sel {
+						st.diff = diff
+					}
+					st.mw.Changed()
+				}()
+			}
+		}
+		sw.GroupEnd()
+	}
+
+	w.LayoutSpacePushScaled(diffbounds)
+	if sw := w.GroupBegin("stash-diff", nucular.WindowBorder); sw != nil {
+		if st.selected >= 0 {
+			if st.diff == nil {
+				sw.Row(20).Dynamic(1)
+				sw.Label("Loading...", "LC")
+			} else {
+				showDiff(sw, st.diff)
+			}
+		}
+		sw.GroupEnd()
+	}
+}
+
+// run runs a git command in the background and reloads everything.
+func (st *stashTab) run(cmdname string, args ...string) {
+	go func() {
+		out, err := runJob(editorCommand(cmdname, args...))
+		if err != nil && err != errJobCancelled {
+			newMessagePopup(st.mw, "Error", fmt.Sprintf("Error: %v\n%s\n", err, out))
+		}
+		refreshAfterChange(st.mw, changeAll)
+	}()
+}
+
+// runStash is like run for commands operating on stash e, nothing is run if
+// e was moved by other stash commands.
+func (st *stashTab) runStash(e stashEntry, cmdname string, args ...string) {
+	go func() {
+		if err := checkStash(&e); err != nil {
+			newMessagePopup(st.mw, "Error", fmt.Sprintf("Error: %v\n", err))
+			reloadStashTab(st.mw)
+			return
+		}
+		out, err := runJob(editorCommand(cmdname, args...))
+		if err != nil && err != errJobCancelled {
+			newMessagePopup(st.mw, "Error", fmt.Sprintf("Error: %v\n%s\n", err, out))
+		}
+		refreshAfterChange(st.mw, changeAll)
+	}()
+}
+
+func newStashDropPopup(st *stashTab, e stashEntry) {
+	st.mw.PopupOpen("Drop stash...", popupFlags, rect.Rect{20, 100, 480, 200}, true, func(w *nucular.Window) {
+		w.Row(25).Dynamic(1)
+		w.Label(fmt.Sprintf("Drop %s: %s?", e.Ref, e.Message), "LC")
+		w.Label(fmt.Sprintf("It can be recovered with: git stash store %s", e.Id), "LC")
+		ok, _ := okCancelButtons(w, false, "Drop", true)
+		if ok {
+			st.runStash(e, "git", "stash", "drop", e.Ref)
+		}
+	})
+}
+
+// stashPopup creates a new stash from the Commit tab.
+type stashPopup struct {
+	mw        nucular.MasterWindow
+	mode      stashMode
+	untracked bool
+	msg       nucular.TextEditor
+	lines     []StatusLine
+	selected  []bool
+	running   bool
+}
+
+func newStashPopup(mw nucular.MasterWindow, lines []StatusLine, selected string) {
+	sp := &stashPopup{mw: mw, lines: lines, selected: make([]bool, len(lines))}
+	sp.msg.Flags = nucular.EditSelectable | nucular.EditClipboard
+	sp.msg.Active = true
+	for i := range lines {
+		if lines[i].Path == selected {
+			sp.selected[i] = true
+			sp.mode = stashFiles
+			sp.untracked = lines[i].Index == "?"
+		}
+	}
+	mw.PopupOpen("Stash...", popupFlags, rect.Rect{20, 100, 560, 500}, true, sp.Update)
+}
+
+func (sp *stashPopup) Update(w *nucular.Window) {
+	w.Row(25).Static(80, 0)
+	w.Label("Message:", "LC")
+	sp.msg.Edit(w)
+
+	w.Row(25).Static(150, 150, 150)
+	if w.OptionText("All changes", sp.mode == stashAll) {
+		sp.mode = stashAll
+	}
+	if w.OptionText("Staged changes", sp.mode == stashStaged) {
+		sp.mode = stashStaged
+	}
+	if w.OptionText("Selected files", sp.mode == stashFiles) {
+		sp.mode = stashFiles
+	}
+	if sp.mode != stashStaged {
+		w.Row(25).Dynamic(1)
+		w.CheckboxText("Include untracked files", &sp.untracked)
+	}
+
+	paths := []string{}
+	if sp.mode == stashFiles {
+		w.Row(200).Dynamic(1)
+		if sw := w.GroupBegin("stash-files", nucular.WindowBorder); sw != nil {
+			sw.Row(20).Dynamic(1)
+			for i := range sp.lines {
+				if sp.lines[i].Index == "!" || (sp.lines[i].Index == "?" && !sp.untracked) {
+					continue
+				}
+				sw.CheckboxText(sp.lines[i].Path, &sp.selected[i])
+				if sp.selected[i] {
+					paths = append(paths, sp.lines[i].Path)
+				}
+			}
+			sw.GroupEnd()
+		}
+	}
+
+	oktext := "Stash"
+	if sp.mode == stashFiles && len(paths) == 0 {
+		oktext = ""
+	}
+	ok, _ := okCancelButtons(w, false, oktext, true)
+	if ok {
+		args := stashArgs(sp.mode, strings.TrimSpace(string(sp.msg.Buffer)), sp.untracked, paths)
+		go func() {
+			out, err := runJob(editorCommand("git", args...))
+			if err != nil && err != errJobCancelled {
+				newMessagePopup(sp.mw, "Error", fmt.Sprintf("Error: %v\n%s\n", err, out))
+			}
+			refreshAfterChange(sp.mw, changeAll)
+		}()
+	}
+}
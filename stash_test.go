@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStash(t *testing.T) {
+	dir := testRepo(t)
+	defer os.RemoveAll(dir)
+
+	testWrite(t, "a.txt", "two\n")
+	testWrite(t, "b.txt", "untracked\n")
+	testGit(t, stashArgs(stashFiles, "only b", true, []string{"b.txt"})...)
+	if _, err := os.Stat(filepath.Join(dir, "b.txt")); err == nil {
+		t.Errorf("b.txt was not stashed")
+	}
+	if testRead(t, "a.txt") != "two\n" {
+		t.Errorf("a.txt should not be stashed")
+	}
+	testGit(t, stashArgs(stashAll, "", false, nil)...)
+
+	stashes, err := stashList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stashes) != 2 || stashes[0].Ref != "stash@{0}" || !strings.HasPrefix(stashes[0].Message, "WIP on ") || stashes[1].Message != "On master: only b" && stashes[1].Message != "On main: only b" {
+		t.Fatalf("wrong stashes %#v", stashes)
+	}
+	head := strings.TrimSpace(testGit(t, "rev-parse", "HEAD"))
+	if stashes[0].Base != head || stashes[0].When.IsZero() {
+		t.Errorf("wrong base or date %#v", stashes[0])
+	}
+
+	diff := stashDiff(stashes[1].Ref)
+	if len(diff) != 1 || diff[0].Filename != "b.txt" {
+		t.Errorf("wrong diff of the stash of b.txt %#v", diff)
+	}
+
+	old := stashes
+	if out, err := renameStash(&stashes[1], "renamed"); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	stashes, _ = stashList()
+	if len(stashes) != 2 || stashes[0].Message != "renamed" || stashes[0].Id != old[1].Id || stashes[1].Id != old[0].Id {
+		t.Errorf("wrong stashes after rename %#v", stashes)
+	}
+
+	// the entries loaded before the rename moved
+	if err := checkStash(&old[0]); err == nil {
+		t.Errorf("stale stash entry not detected")
+	}
+	if _, err := renameStash(&old[1], "again"); err == nil {
+		t.Errorf("stale stash entry renamed")
+	}
+	if err := checkStash(&stashes[1]); err != nil {
+		t.Errorf("current stash entry: %v", err)
+	}
+
+	if got := strings.Join(stashArgs(stashStaged, "msg", true, nil), " "); got != "stash push -m msg --staged" {
+		t.Errorf("wrong arguments %q", got)
+	}
+}
+
+func TestStashApplyIndex(t *testing.T) {
+	dir := testRepo(t)
+	defer os.RemoveAll(dir)
+
+	testWrite(t, "a.txt", "two\n")
+	testGit(t, "add", "a.txt")
+	testGit(t, stashArgs(stashAll, "", false, nil)...)
+
+	testGit(t, stashApplyArgs("apply", "stash@{0}", false)...)
+	if out := testGit(t, "diff", "--cached", "--name-only"); out != "" {
+		t.Errorf("index restored without --index: %q", out)
+	}
+	testGit(t, "checkout", "-q", "--", "a.txt")
+
+	testGit(t, stashApplyArgs("pop", "stash@{0}", true)...)
+	if out := testGit(t, "diff", "--cached", "--name-only"); out != "a.txt\n" {
+		t.Errorf("index not restored: %q", out)
+	}
+	if stashes, _ := stashList(); len(stashes) != 0 {
+		t.Errorf("stash not dropped %#v", stashes)
+	}
+}
@@ -1,74 +1,238 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"fmt"
+	"os/exec"
 	"strings"
 )
 
 type StatusLine struct {
 	Index, WorkDir string
 	Path           string
-	Path2          string
+	Path2          string // original path of a renamed or copied file
+	Score          string // rename or copy score (for example R100)
+	Submodule      string // submodule state, "N..." if Path is not a submodule
 }
 
 type GitStatus struct {
-	Branch string
-	Lines  []StatusLine
+	Oid            string // commit id of HEAD, empty before the first commit
+	Branch         string // empty if HEAD is detached
+	Upstream       string
+	HasAheadBehind bool
+	Ahead, Behind  int
+	Lines          []StatusLine
 }
 
-func gitStatus() *GitStatus {
-	r := GitStatus{}
+func gitStatus() (*GitStatus, error) {
+	return gitStatusContext(context.Background(), false)
+}
 
-	const arrow = " -> "
+func gitStatusContext(ctx context.Context, ignored bool) (*GitStatus, error) {
+	args := []string{"--no-optional-locks", "status", "--porcelain=v2", "-z", "--branch"}
+	if ignored {
+		args = append(args, "--ignored")
+	}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = Repodir
+	bs, err := cmd.Output()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		if exiterr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("git status: %v\n%s", err, exiterr.Stderr)
+		}
+		return nil, err
+	}
+	return parseStatus(bs)
+}
 
-	bs, err := execCommand("git", "status", "--porcelain", "-b")
-	must(err)
-	rdr := bufio.NewScanner(bytes.NewReader([]byte(bs)))
-	for rdr.Scan() {
-		text := rdr.Text()
-		if text[0] == '#' && text[1] == '#' {
-			if text[3:] != "HEAD (no branch)" {
-				r.Branch = text[3:]
+// parseStatus parses the output of git status --porcelain=v2 -z --branch
+func parseStatus(bs []byte) (*GitStatus, error) {
+	r := &GitStatus{}
+
+	xy := func(s string) (string, string) {
+		s = strings.Replace(s, ".", " ", -1)
+		return s[:1], s[1:]
+	}
+
+	recs := strings.Split(string(bs), "\x00")
+	for i := 0; i < len(recs); i++ {
+		rec := recs[i]
+		if rec == "" {
+			continue
+		}
+		var fields []string
+		n := 0
+		switch rec[0] {
+		case '#':
+			fields = strings.SplitN(rec, " ", 3)
+			if len(fields) != 3 {
+				continue
+			}
+			switch fields[1] {
+			case "branch.oid":
+				if fields[2] != "(initial)" {
+					r.Oid = fields[2]
+				}
+			case "branch.head":
+				if fields[2] != "(detached)" {
+					r.Branch = fields[2]
+				}
+			case "branch.upstream":
+				r.Upstream = fields[2]
+			case "branch.ab":
+				if _, err := fmt.Sscanf(fields[2], "+%d -%d", &r.Ahead, &r.Behind); err == nil {
+					r.HasAheadBehind = true
+				}
 			}
-		} else {
-			line := StatusLine{Index: string(text[0]), WorkDir: string(text[1]), Path: text[3:]}
+			continue
+		case '1':
+			n = 9
+		case '2':
+			n = 10
+		case 'u':
+			n = 11
+		case '?', '!':
+			if len(rec) < 3 {
+				return nil, fmt.Errorf("malformed status record %q", rec)
+			}
+			code := rec[:1]
+			r.Lines = append(r.Lines, StatusLine{Index: code, WorkDir: code, Path: rec[2:]})
+			continue
+		default:
+			return nil, fmt.Errorf("unknown status record %q", rec)
+		}
 
-			if idx := strings.Index(line.Path, arrow); idx >= 0 {
-				line.Path2 = line.Path[idx+len(arrow):]
-				line.Path = line.Path[:idx]
+		fields = strings.SplitN(rec, " ", n)
+		if len(fields) != n || len(fields[1]) != 2 {
+			return nil, fmt.Errorf("malformed status record %q", rec)
+		}
+
+		var line StatusLine
+		line.Index, line.WorkDir = xy(fields[1])
+		line.Submodule = fields[2]
+		line.Path = fields[len(fields)-1]
+		if rec[0] == '2' {
+			line.Score = fields[8]
+			i++
+			if i >= len(recs) {
+				return nil, fmt.Errorf("missing original path for %q", line.Path)
 			}
+			line.Path2 = recs[i]
+		}
+		r.Lines = append(r.Lines, line)
+	}
+	return r, nil
+}
+
+// IsSubmodule returns true if the path is a submodule
+func (line *StatusLine) IsSubmodule() bool {
+	return strings.HasPrefix(line.Submodule, "S")
+}
+
+// Unmerged returns true if the file has unresolved merge conflicts
+func (line *StatusLine) Unmerged() bool {
+	switch line.Index + line.WorkDir {
+	case "DD", "AU", "UD", "UA", "DU", "AA", "UU":
+		return true
+	}
+	return false
+}
+
+// PartiallyStaged returns true if the file has both staged and unstaged changes
+func (line *StatusLine) PartiallyStaged() bool {
+	return line.Index != " " && line.Index != "?" && line.WorkDir != " " && !line.Unmerged()
+}
+
+type statusCategory int
+
+const (
+	categoryModified statusCategory = iota
+	categoryAdded
+	categoryDeleted
+	categoryUntracked
+	categoryConflicted
+	categoryIgnored
+	numStatusCategories
+)
+
+var statusCategoryNames = [numStatusCategories]string{"Modified", "Added", "Deleted", "Untracked", "Conflicted", "Ignored"}
+
+// Category returns the kind of change of the file, renamed and copied
+// files are considered modified.
+func (line *StatusLine) Category() statusCategory {
+	switch {
+	case line.Unmerged():
+		return categoryConflicted
+	case line.Index == "?":
+		return categoryUntracked
+	case line.Index == "!":
+		return categoryIgnored
+	case line.Index == "A" || line.WorkDir == "A":
+		return categoryAdded
+	case line.Index == "D" || line.WorkDir == "D":
+		return categoryDeleted
+	}
+	return categoryModified
+}
 
-			r.Lines = append(r.Lines, line)
+func (line *StatusLine) canDiscard() bool {
+	return !line.Unmerged() && line.Index != "R" && line.Index != "C" && line.Index != "!"
+}
+
+func (status *GitStatus) Unmerged() []StatusLine {
+	r := []StatusLine{}
+	for _, line := range status.Lines {
+		if line.Unmerged() {
+			r = append(r, line)
 		}
 	}
-	must(rdr.Err())
-	return &r
+	return r
 }
 
 func (status *GitStatus) Summary() string {
 	index := false
 	workdir := false
+	conflicts := false
 	for _, line := range status.Lines {
-		if line.Index != " '" {
+		switch {
+		case line.Unmerged():
+			conflicts = true
+		case line.Index != " " && line.Index != "?" && line.Index != "!":
 			index = true
 		}
-		if line.WorkDir != " " {
+		if line.WorkDir != " " && line.WorkDir != "!" {
 			workdir = true
 		}
-		if index && workdir {
-			break
-		}
 	}
 
-	if index && workdir {
-		return fmt.Sprintf("On branch %s: Unmerged changes, dirty working directory", status.Branch)
-	} else if !index && workdir {
-		return fmt.Sprintf("On branch %s: Dirty working directory", status.Branch)
-	} else if index && !workdir {
-		return fmt.Sprintf("On branch %s: Unmerged changes", status.Branch)
+	var buf bytes.Buffer
+	if status.Branch != "" {
+		fmt.Fprintf(&buf, "On branch %s", status.Branch)
 	} else {
-		return fmt.Sprintf("On branch %s", status.Branch)
+		fmt.Fprintf(&buf, "HEAD detached")
+	}
+	if status.Upstream != "" && status.HasAheadBehind && (status.Ahead != 0 || status.Behind != 0) {
+		fmt.Fprintf(&buf, " (ahead %d, behind %d of %s)", status.Ahead, status.Behind, status.Upstream)
+	}
+
+	sep := ": "
+	add := func(s string) {
+		buf.WriteString(sep)
+		buf.WriteString(s)
+		sep = ", "
+	}
+	if conflicts {
+		add("Merge conflicts")
+	}
+	if index {
+		add("Staged changes")
+	}
+	if workdir {
+		add("Dirty working directory")
 	}
+	return buf.String()
 }
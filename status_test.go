@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseStatus(t *testing.T) {
+	in := strings.Join([]string{
+		"# branch.oid 1234567890123456789012345678901234567890",
+		"# branch.head master",
+		"# branch.upstream origin/master",
+		"# branch.ab +2 -3",
+		"1 .M N... 100644 100644 100644 1111111111111111111111111111111111111111 1111111111111111111111111111111111111111 a -> b.txt",
+		"2 R. N... 100644 100644 100644 2222222222222222222222222222222222222222 2222222222222222222222222222222222222222 R95 new name.txt",
+		"old name.txt",
+		"u UU N... 100644 100644 100644 100644 3333333333333333333333333333333333333333 4444444444444444444444444444444444444444 5555555555555555555555555555555555555555 conflict.txt",
+		"1 .M S.M. 160000 160000 160000 6666666666666666666666666666666666666666 6666666666666666666666666666666666666666 sub",
+		"? àèìòù.txt",
+		"",
+	}, "\x00")
+
+	status, err := parseStatus([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Branch != "master" || status.Upstream != "origin/master" || !status.HasAheadBehind || status.Ahead != 2 || status.Behind != 3 {
+		t.Errorf("wrong branch information %#v", status)
+	}
+	tgt := []StatusLine{
+		{Index: " ", WorkDir: "M", Path: "a -> b.txt", Submodule: "N..."},
+		{Index: "R", WorkDir: " ", Path: "new name.txt", Path2: "old name.txt", Score: "R95", Submodule: "N..."},
+		{Index: "U", WorkDir: "U", Path: "conflict.txt", Submodule: "N..."},
+		{Index: " ", WorkDir: "M", Path: "sub", Submodule: "S.M."},
+		{Index: "?", WorkDir: "?", Path: "àèìòù.txt"},
+	}
+	if len(status.Lines) != len(tgt) {
+		t.Fatalf("wrong number of lines %d", len(status.Lines))
+	}
+	for i := range tgt {
+		if status.Lines[i] != tgt[i] {
+			t.Errorf("line %d: got %#v expected %#v", i, status.Lines[i], tgt[i])
+		}
+	}
+	if !status.Lines[2].Unmerged() || !status.Lines[3].IsSubmodule() {
+		t.Errorf("wrong unmerged or submodule state")
+	}
+	if s := status.Summary(); s != "On branch master (ahead 2, behind 3 of origin/master): Merge conflicts, Staged changes, Dirty working directory" {
+		t.Errorf("wrong summary %q", s)
+	}
+
+	status, err = parseStatus([]byte("# branch.oid (initial)\x00# branch.head (detached)\x00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := status.Summary(); s != "HEAD detached" {
+		t.Errorf("wrong summary %q", s)
+	}
+
+	if _, err := parseStatus([]byte("1 .M N...\x00")); err == nil {
+		t.Errorf("no error for malformed record")
+	}
+}
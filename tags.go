@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aarzilli/nucular"
+	"github.com/aarzilli/nucular/rect"
+)
+
+// tagInfo describes an annotated tag, lightweight tags have no tagInfo.
+type tagInfo struct {
+	Tagger  string
+	When    time.Time
+	Message string
+}
+
+// tagInfos returns the tagger and message of every annotated tag, by
+// name of the tag.
+func tagInfos() (map[string]tagInfo, error) {
+	out, err := execCommand("git", "for-each-ref", "--format=%(refname:strip=2)%00%(objecttype)%00%(taggername) %(taggeremail)%00%(taggerdate:unix)%00%(contents)%01", "refs/tags")
+	if err != nil {
+		return nil, fmt.Errorf("git for-each-ref: %v\n%s", err, out)
+	}
+	return parseTagInfos(out), nil
+}
+
+func parseTagInfos(out string) map[string]tagInfo {
+	r := map[string]tagInfo{}
+	for _, rec := range strings.Split(out, "\x01") {
+		v := strings.SplitN(strings.TrimPrefix(rec, "\n"), "\x00", 5)
+		if len(v) != 5 || v[1] != "tag" {
+			continue
+		}
+		info := tagInfo{Tagger: strings.TrimSpace(v[2]), Message: strings.TrimSpace(v[4])}
+		if ts, err := strconv.ParseInt(v[3], 10, 64); err == nil {
+			info.When = time.Unix(ts, 0)
+		}
+		r[v[0]] = info
+	}
+	return r
+}
+
+// tagArgs returns the arguments of the git tag command creating a tag,
+// the message of annotated tags is read from standard input.
+func tagArgs(name, commitId string, annotated, sign bool) []string {
+	args := []string{"tag"}
+	switch {
+	case sign:
+		args = append(args, "-s", "-F", "-")
+	case annotated:
+		args = append(args, "-a", "-F", "-")
+	}
+	return append(args, name, commitId)
+}
+
+func remoteNames() []string {
+	r := []string{}
+	for name := range allRemotes() {
+		r = append(r, name)
+	}
+	sort.Strings(r)
+	return r
+}
+
+type newTagPopup struct {
+	mw        nucular.MasterWindow
+	commitId  string
+	annotated bool
+	sign      bool
+	name      nucular.TextEditor
+	msg       nucular.TextEditor
+}
+
+func newNewTagPopup(mw nucular.MasterWindow, commitId string) {
+	tp := &newTagPopup{mw: mw, commitId: commitId, annotated: true}
+	tp.name.Flags = nucular.EditSelectable | nucular.EditClipboard
+	tp.name.Active = true
+	tp.name.Maxlen = 128
+	tp.msg.Flags = nucular.EditSelectable | nucular.EditMultiline | nucular.EditClipboard
+	mw.PopupOpen("New tag...", popupFlags, rect.Rect{20, 100, 480, 400}, true, tp.Update)
+}
+
+func (tp *newTagPopup) Update(w *nucular.Window) {
+	w.Row(25).Static(80, 0)
+	w.Label("Name:", "LC")
+	tp.name.Edit(w)
+
+	w.Row(25).Static(120, 120, 120)
+	if w.OptionText("Lightweight", !tp.annotated) {
+		tp.annotated = false
+		tp.sign = false
+	}
+	if w.OptionText("Annotated", tp.annotated) {
+		tp.annotated = true
+	}
+	if w.CheckboxText("Sign", &tp.sign) && tp.sign {
+		tp.annotated = true
+	}
+
+	if tp.annotated {
+		w.Row(25).Dynamic(1)
+		w.Label("Message:", "LC")
+		w.Row(150).Dynamic(1)
+		tp.msg.Edit(w)
+	}
+
+	name := strings.TrimSpace(string(tp.name.Buffer))
+	msg := strings.TrimSpace(string(tp.msg.Buffer))
+	oktext := "Create"
+	if name == "" || (tp.annotated && msg == "") {
+		oktext = ""
+	}
+	ok, _ := okCancelButtons(w, false, oktext, true)
+	if ok {
+		args := tagArgs(name, tp.commitId, tp.annotated, tp.sign)
+		go func() {
+			cmd := editorCommand("git", args...)
+			cmd.Stdin = strings.NewReader(msg + "\n")
+			out, err := runJob(cmd)
+			if err != nil && err != errJobCancelled {
+				newMessagePopup(tp.mw, "Error", fmt.Sprintf("Error: %v\n%s\n", err, out))
+			}
+			refreshAfterChange(tp.mw, changeRefs)
+		}()
+	}
+}
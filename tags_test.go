@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTags(t *testing.T) {
+	dir := testRepo(t)
+	defer os.RemoveAll(dir)
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+
+	head := strings.TrimSpace(testGit(t, "rev-parse", "HEAD"))
+	testGit(t, tagArgs("light", head, false, false)...)
+	if out, err := execCommandStdin("Release 1.0\n\nDetails.\n", "git", tagArgs("v1.0", head, true, false)...); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+
+	refs, err := allRefs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]string{}
+	for _, ref := range refs {
+		if ref.Kind == TagRef {
+			found[ref.Nice()] = ref.CommitId
+		}
+	}
+	if len(found) != 2 || found["light"] != head || found["v1.0"] != head {
+		t.Errorf("wrong tags %v", found)
+	}
+
+	infos, err := tagInfos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := infos["light"]; ok || len(infos) != 1 {
+		t.Errorf("wrong tag infos %#v", infos)
+	}
+	info := infos["v1.0"]
+	if info.Message != "Release 1.0\n\nDetails." || info.Tagger != "test <test@example.com>" || info.When.IsZero() {
+		t.Errorf("wrong tag info %#v", info)
+	}
+
+	if got := strings.Join(tagArgs("v2", head, true, true), " "); got != "tag -s -F - v2 "+head {
+		t.Errorf("wrong arguments %q", got)
+	}
+}
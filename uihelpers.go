@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"image"
+	"strconv"
 	"strings"
 	"time"
 
@@ -212,6 +213,34 @@ func (np *newBranchPopup) Update(w *nucular.Window) {
 	}
 }
 
+func newConfirmPopup(mw nucular.MasterWindow, title, text, oktext string, onOk func()) {
+	mw.PopupOpen(title, popupFlags, rect.Rect{20, 100, 480, 150}, true, func(w *nucular.Window) {
+		w.Row(25).Dynamic(1)
+		w.Label(text, "LC")
+		ok, _ := okCancelButtons(w, true, oktext, true)
+		if ok {
+			onOk()
+		}
+	})
+}
+
+func newInputPopup(mw nucular.MasterWindow, title, text, initial string, onOk func(string)) {
+	var ed nucular.TextEditor
+	ed.Flags = nucular.EditSigEnter | nucular.EditSelectable | nucular.EditClipboard
+	ed.Buffer = []rune(initial)
+	ed.Cursor = len(ed.Buffer)
+	ed.Active = true
+	mw.PopupOpen(title, popupFlags, rect.Rect{20, 100, 480, 200}, true, func(w *nucular.Window) {
+		w.Row(25).Dynamic(1)
+		w.Label(text, "LC")
+		ed.Edit(w)
+		ok, _ := okCancelButtons(w, !ed.Active, "OK", true)
+		if s := strings.TrimSpace(string(ed.Buffer)); ok && s != "" {
+			onOk(s)
+		}
+	})
+}
+
 type resetMode int
 
 const (
@@ -407,3 +436,127 @@ func (prp *pullRequestPopup) Update(w *nucular.Window) {
 		githubStuff.pullRequest(&lw, string(prp.ed.Buffer), prp.githubRef, prp.lc)
 	}
 }
+
+type rebasePopup struct {
+	Lc   LanedCommit
+	Refs []Ref
+
+	onto         bool
+	ontoIdx      int
+	autosquash   bool
+	updateRefs   bool
+	rebaseMerges bool
+
+	names         []string
+	counting      bool // count and mcount are being computed
+	count, mcount int
+	counterr      error
+}
+
+func newRebasePopup(mw nucular.MasterWindow, refs []Ref, lc LanedCommit) {
+	rp := &rebasePopup{Lc: lc, Refs: refs, ontoIdx: -1, autosquash: true, counting: true}
+	rp.names = make([]string, len(refs))
+	for i := range refs {
+		rp.names[i] = refs[i].Nice()
+	}
+	go func() {
+		count, err := countCommits(lc.Id+"..HEAD", "--no-merges")
+		mcount := 0
+		if err == nil {
+			mcount, err = countCommits(lc.Id + "..HEAD")
+		}
+		mw.Lock()
+		rp.counting = false
+		rp.count, rp.mcount, rp.counterr = count, mcount, err
+		mw.Unlock()
+		mw.Changed()
+	}()
+	mw.PopupOpen("Rebase...", popupFlags, rect.Rect{20, 100, 480, 500}, true, rp.Update)
+}
+
+func countCommits(commitRange string, flags ...string) (int, error) {
+	args := append([]string{"rev-list", "--count"}, flags...)
+	out, err := execCommand("git", append(args, commitRange)...)
+	if err != nil {
+		return 0, fmt.Errorf("%v: %s", err, out)
+	}
+	return strconv.Atoi(strings.TrimSpace(out))
+}
+
+func (rp *rebasePopup) Update(w *nucular.Window) {
+	w.Row(25).Dynamic(1)
+	w.Label(fmt.Sprintf("Upstream: %s", rp.Lc.NiceWithAbbrev()), "LC")
+	w.CheckboxText("Onto a different base (--onto)", &rp.onto)
+	if rp.onto {
+		w.Row(150).Dynamic(1)
+		rp.ontoIdx = selectFromList(w, "rebase-onto", rp.ontoIdx, rp.names, true)
+		w.Row(25).Dynamic(1)
+	}
+	w.CheckboxText("Squash fixup! and squash! commits (--autosquash)", &rp.autosquash)
+	w.CheckboxText("Update stacked branches (--update-refs)", &rp.updateRefs)
+	w.CheckboxText("Keep merges (--rebase-merges)", &rp.rebaseMerges)
+
+	switch {
+	case rp.counting:
+		w.Label("Counting commits...", "LC")
+	case rp.counterr != nil:
+		w.Label(fmt.Sprintf("Error: %v", rp.counterr), "LC")
+	case rp.onto && rp.ontoIdx >= 0:
+		w.Label(fmt.Sprintf("%d commits will be replayed onto %s", rp.replayed(), rp.names[rp.ontoIdx]), "LC")
+	default:
+		w.Label(fmt.Sprintf("%d commits will be replayed", rp.replayed()), "LC")
+	}
+
+	oktext := "Rebase"
+	if rp.onto && rp.ontoIdx < 0 {
+		// nothing to rebase onto yet
+		oktext = ""
+	}
+	ok, _ := okCancelButtons(w, false, oktext, true)
+	if ok {
+		args := []string{}
+		if rp.autosquash {
+			args = append(args, "--autosquash")
+		} else {
+			args = append(args, "--no-autosquash")
+		}
+		if rp.updateRefs {
+			args = append(args, "--update-refs")
+		}
+		if rp.rebaseMerges {
+			args = append(args, "--rebase-merges")
+		}
+		if rp.onto {
+			args = append(args, "--onto", rp.Refs[rp.ontoIdx].Nice())
+		}
+		rebaseAction(&lw, append(args, rp.Lc.Id)...)
+	}
+}
+
+func (rp *rebasePopup) replayed() int {
+	if rp.rebaseMerges {
+		return rp.mcount
+	}
+	return rp.count
+}
+
+// checkboxTristate is a checkbox that can also be in a third, indeterminate,
+// state, drawn as a bar inside the box. Clicking it in the indeterminate
+// state will check it.
+func checkboxTristate(w *nucular.Window, checked *bool, indeterminate bool) bool {
+	changed := w.CheckboxText("", checked)
+	if indeterminate && !*checked && !changed {
+		style := w.Master().Style()
+		bounds := w.LastWidgetBounds
+		sz := min(bounds.H, nucular.FontHeight(style.Font)+style.Checkbox.Padding.Y)
+		pad := sz / 4
+		bar := rect.Rect{
+			X: bounds.X + style.Checkbox.Padding.X + pad,
+			Y: bounds.Y + bounds.H/2 - pad/2,
+			W: sz - 2*pad,
+			H: pad,
+		}
+		w.Commands().FillRect(bar, 0, style.Checkbox.CursorNormal.Data.Color)
+	}
+	return changed
+}
@@ -79,6 +79,7 @@ func (vw *ViewWindow) parseDiff() {
 	}
 
 	vw.diff = parseDiff(bs)
+	describeBinary(vw.diff)
 }
 
 var (
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/aarzilli/nucular"
+)
+
+// The watcher reports changes to the work tree and to the files in .git
+// that describe the state of the repository, changes are collected until
+// nothing happens for watchDebounce (or for at most watchMaxDelay) and
+// then the affected tabs are reloaded in the background.
+
+type watchChange uint8
+
+const (
+	changeWorktree watchChange = 1 << iota
+	changeIndex
+	changeRefs
+	changeSequencer
+	changeAll = changeWorktree | changeIndex | changeRefs | changeSequencer
+)
+
+const (
+	watchDebounce = 300 * time.Millisecond
+	watchMaxDelay = 2 * time.Second
+	// watchMaxPaths is the maximum number of changed work tree paths checked
+	// against the ignore rules, past it the change is always considered.
+	watchMaxPaths = 1000
+)
+
+type watchEvent struct {
+	change watchChange
+	path   string // changed path relative to Repodir, for changeWorktree
+}
+
+// classifyGitPath returns the kind of change signaled by a change of path,
+// a path relative to the git directory.
+func classifyGitPath(path string) watchChange {
+	if strings.HasSuffix(path, ".lock") {
+		return 0
+	}
+	switch path {
+	case "index":
+		return changeIndex
+	case "HEAD", "ORIG_HEAD", "FETCH_HEAD", "packed-refs":
+		return changeRefs
+	case "MERGE_HEAD", "CHERRY_PICK_HEAD", "REVERT_HEAD", "REBASE_HEAD", "BISECT_LOG", "rebase-merge", "rebase-apply", "sequencer":
+		return changeSequencer
+	}
+	if strings.HasPrefix(path, "refs/") {
+		return changeRefs
+	}
+	return 0
+}
+
+func watchLoop(mw nucular.MasterWindow, events <-chan watchEvent) {
+	var change watchChange
+	var first time.Time
+	var timer <-chan time.Time
+	paths := map[string]bool{}
+	overflow := false
+
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if change == 0 {
+				first = time.Now()
+			}
+			change |= ev.change
+			if ev.path != "" {
+				if len(paths) < watchMaxPaths {
+					paths[ev.path] = true
+				} else {
+					overflow = true
+				}
+			}
+			d := watchDebounce
+			if rem := watchMaxDelay - time.Since(first); rem < d {
+				d = rem
+			}
+			timer = time.After(d)
+
+		case <-timer:
+			if change == changeWorktree && !overflow && allIgnored(paths) {
+				change = 0
+			}
+			refreshAfterChange(mw, change)
+			change = 0
+			timer = nil
+			paths = map[string]bool{}
+			overflow = false
+		}
+	}
+}
+
+// allIgnored returns true if all paths are ignored.
+func allIgnored(paths map[string]bool) bool {
+	if len(paths) == 0 {
+		return true
+	}
+	var buf strings.Builder
+	for path := range paths {
+		buf.WriteString(path)
+		buf.WriteByte(0)
+	}
+	out, err := execCommandStdin(buf.String(), "git", "check-ignore", "-z", "--stdin")
+	if exiterr, ok := err.(*exec.ExitError); ok && exiterr.ExitCode() == 1 {
+		// exit status 1 means that none of the paths is ignored
+		return false
+	}
+	if err != nil {
+		return false
+	}
+	n := 0
+	for _, path := range strings.Split(out, "\x00") {
+		if paths[path] {
+			n++
+		}
+	}
+	return n >= len(paths)
+}
+
+func refreshAfterChange(mw nucular.MasterWindow, change watchChange) {
+	if change == 0 {
+		return
+	}
+	idxmw.reload()
+
+	fingerprint := ""
+	if change&changeRefs != 0 {
+		fingerprint = refsFingerprint()
+	}
+	lw.mu.Lock()
+	lw.refresh(fingerprint)
+	lw.mu.Unlock()
+
+	if change&(changeRefs|changeSequencer) != 0 {
+		checkSequencerStateAsync(mw)
+	}
+	if change&changeRefs != 0 {
+		reloadStashTab(mw)
+		reloadRefsTab(mw)
+		reloadRemotesTab(mw)
+	}
+	mw.Changed()
+}
+
+// refsFingerprint returns a string that changes whenever HEAD or one of the
+// references changes.
+func refsFingerprint() string {
+	head, _ := execCommand("git", "symbolic-ref", "-q", "HEAD")
+	out, _ := execCommand("git", "show-ref", "--head")
+	return head + out
+}
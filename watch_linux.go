@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/aarzilli/nucular"
+)
+
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ATTRIB | syscall.IN_DELETE_SELF
+
+type inotifyWatch struct {
+	dir    string // absolute path of the watched directory
+	rel    string // path relative to the work tree or git directory
+	gitdir bool   // dir is the git directory or one of its subdirectories
+}
+
+type inotifyWatcher struct {
+	fd     int
+	events chan watchEvent
+
+	mu      sync.Mutex
+	watches map[int32]inotifyWatch
+	full    bool // the limit on the number of watches was reached
+}
+
+// startWatcher starts watching the work tree and git directory of Repodir
+// for changes, directories excluded by the ignore rules are not watched.
+func startWatcher(mw nucular.MasterWindow) error {
+	gitdir, err := execCommand("git", "rev-parse", "--absolute-git-dir")
+	if err != nil {
+		return fmt.Errorf("git rev-parse: %v\n%s", err, gitdir)
+	}
+	gitdir = strings.TrimSpace(gitdir)
+
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return fmt.Errorf("inotify: %v", err)
+	}
+
+	iw := &inotifyWatcher{fd: fd, events: make(chan watchEvent, 1024), watches: make(map[int32]inotifyWatch)}
+
+	// the git directory is watched first so that it is always watched even
+	// if there are too many directories in the work tree
+	iw.add(inotifyWatch{dir: gitdir, gitdir: true})
+	iw.addTree(filepath.Join(gitdir, "refs"), "refs", true, nil)
+
+	go iw.readLoop()
+	go watchLoop(mw, iw.events)
+	go iw.addTree(Repodir, "", false, ignoredDirs())
+	return nil
+}
+
+// ignoredDirs returns the set of directories of the work tree excluded by
+// the ignore rules.
+func ignoredDirs() map[string]bool {
+	r := map[string]bool{}
+	out, err := execCommand("git", "ls-files", "-z", "--others", "--ignored", "--exclude-standard", "--directory")
+	if err != nil {
+		return r
+	}
+	for _, path := range strings.Split(out, "\x00") {
+		if strings.HasSuffix(path, "/") {
+			r[strings.TrimSuffix(path, "/")] = true
+		}
+	}
+	return r
+}
+
+func (iw *inotifyWatcher) add(w inotifyWatch) bool {
+	iw.mu.Lock()
+	defer iw.mu.Unlock()
+	if iw.full {
+		return false
+	}
+	wd, err := syscall.InotifyAddWatch(iw.fd, w.dir, inotifyMask)
+	if err != nil {
+		if err == syscall.ENOSPC {
+			iw.full = true
+			fmt.Fprintf(os.Stderr, "inotify watch limit reached, changes to some directories will not be noticed (see fs.inotify.max_user_watches)\n")
+		}
+		return false
+	}
+	iw.watches[int32(wd)] = w
+	return true
+}
+
+// addTree watches dir and all its subdirectories, except the directories
+// in ignored and the .git directory of the work tree.
+func (iw *inotifyWatcher) addTree(dir, rel string, gitdir bool, ignored map[string]bool) {
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		r, _ := filepath.Rel(dir, path)
+		r = filepath.ToSlash(filepath.Join(rel, r))
+		if r == "." {
+			r = ""
+		}
+		if (!gitdir && info.Name() == ".git") || ignored[r] {
+			return filepath.SkipDir
+		}
+		if !iw.add(inotifyWatch{dir: path, rel: r, gitdir: gitdir}) {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+func (iw *inotifyWatcher) readLoop() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := syscall.Read(iw.fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || n <= 0 {
+			close(iw.events)
+			return
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			name := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]
+			if i := bytes.IndexByte(name, 0); i >= 0 {
+				name = name[:i]
+			}
+			off += syscall.SizeofInotifyEvent + int(ev.Len)
+			iw.handle(ev.Wd, ev.Mask, string(name))
+		}
+	}
+}
+
+func (iw *inotifyWatcher) handle(wd int32, mask uint32, name string) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		iw.events <- watchEvent{change: changeAll}
+		return
+	}
+
+	iw.mu.Lock()
+	w, ok := iw.watches[wd]
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(iw.watches, wd)
+	}
+	iw.mu.Unlock()
+	if !ok || name == "" {
+		return
+	}
+
+	path := name
+	if w.rel != "" {
+		path = w.rel + "/" + name
+	}
+
+	if w.gitdir {
+		if mask&syscall.IN_ISDIR != 0 && mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 && strings.HasPrefix(path, "refs/") {
+			iw.addTree(filepath.Join(w.dir, name), path, true, nil)
+		}
+		if change := classifyGitPath(path); change != 0 {
+			iw.events <- watchEvent{change: change}
+		}
+		return
+	}
+
+	if name == ".git" {
+		return
+	}
+	if mask&syscall.IN_ISDIR != 0 && mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+		if _, err := execCommand("git", "check-ignore", "-q", "--", path); err != nil {
+			iw.addTree(filepath.Join(w.dir, name), path, false, nil)
+		}
+	}
+	iw.events <- watchEvent{change: changeWorktree, path: path}
+}
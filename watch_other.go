@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"github.com/aarzilli/nucular"
+)
+
+// startWatcher is not implemented on this platform, Ctrl-R must be used to
+// reload.
+func startWatcher(mw nucular.MasterWindow) error {
+	return nil
+}
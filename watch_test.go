@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestClassifyGitPath(t *testing.T) {
+	for _, tc := range []struct {
+		path   string
+		change watchChange
+	}{
+		{"index", changeIndex},
+		{"index.lock", 0},
+		{"HEAD", changeRefs},
+		{"HEAD.lock", 0},
+		{"packed-refs", changeRefs},
+		{"FETCH_HEAD", changeRefs},
+		{"refs/heads/master", changeRefs},
+		{"refs/heads/master.lock", 0},
+		{"refs/remotes/origin/feature/x", changeRefs},
+		{"MERGE_HEAD", changeSequencer},
+		{"rebase-merge", changeSequencer},
+		{"BISECT_LOG", changeSequencer},
+		{"objects/12", 0},
+		{"logs/HEAD", 0},
+		{"COMMIT_EDITMSG", 0},
+	} {
+		if got := classifyGitPath(tc.path); got != tc.change {
+			t.Errorf("%s: got %d expected %d", tc.path, got, tc.change)
+		}
+	}
+}
+
+func TestAllIgnored(t *testing.T) {
+	dir := testRepo(t)
+	defer os.RemoveAll(dir)
+
+	testWrite(t, ".gitignore", "*.o\n")
+	if !allIgnored(map[string]bool{"x.o": true, "y.o": true}) {
+		t.Errorf("x.o and y.o should be ignored")
+	}
+	if allIgnored(map[string]bool{"x.o": true, "a.txt": true}) {
+		t.Errorf("a.txt should not be ignored")
+	}
+	if allIgnored(map[string]bool{"b.txt": true}) {
+		t.Errorf("b.txt should not be ignored")
+	}
+}